@@ -2,17 +2,24 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"time"
 
-	"github.com/cheggaaa/pb"
+	"github.com/thedahv/keyword-cluster-finder/pkg/cache"
+	"github.com/thedahv/keyword-cluster-finder/pkg/candidates"
+	"github.com/thedahv/keyword-cluster-finder/pkg/cli"
 	"github.com/thedahv/keyword-cluster-finder/pkg/data"
 	"github.com/thedahv/keyword-cluster-finder/pkg/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
 	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/snapshot"
+	"github.com/thedahv/keyword-cluster-finder/pkg/sqlite"
 )
 
 const rboPValue = 0.9
@@ -20,62 +27,219 @@ const rboPValue = 0.9
 func main() {
 	// Required
 	var domainID = flag.Int("domainID", 0, "Domain ID")
-	var configPath = flag.String("config", "", "app JSON config")
 
 	// Optional
+	var configPath = flag.String("config", "", "app JSON config (default connects using PG* environment variables)")
 	var p = flag.Float64("p", 0.9, "RBO p value")
 	var pow = flag.Int("pow", 5, "Cluster power")
 	var inf = flag.Int("inf", 2, "Cluster inflation")
+	var secondary = flag.Float64("secondary", 0.5, "Minimum affinity for secondary cluster membership (0 disables)")
+	var previousPath = flag.String("previous", "", "saved output of a previous run to carry cluster IDs from")
+	var outPath = flag.String("out", "", "path to save the cluster output to")
+	var saveResults = flag.Bool("save-results", false, "record the run in the database's cluster results tables")
+	var snapshotPath = flag.String("snapshots", "", "snapshot store to record the fetched SERP data in, dated by -date, -to or else today")
+	var datasetPath = flag.String("dataset", "", "SQLite dataset to read SERPs from instead of the product database")
+	var queriesDir = flag.String("queries", "", "directory of SQL query templates for a different warehouse schema (default our product database)")
+	var progressKind = flag.String("progress", "bar", "how to report progress: bar, log or none")
+	var candidateKind = flag.String("candidates", "all", "which keyword pairs to compute similarity for: all, index to only compare pairs sharing domains, or minhash to only compare pairs likely to be similar")
+	var minShared = flag.Int("min-shared", 1, "with -candidates index, how many domains two SERPs must share to be compared")
+	var maxDomainShare = flag.Float64("max-domain-share", 0, "with -candidates index, ignore domains ranking for more than this share of keywords (default 0, use every domain)")
+	var idf = flag.Bool("idf", false, "weight domains by inverse document frequency, so ones ranking for most keywords link them less")
+	var stoplist = flag.String("stoplist", "", "comma-separated domains to leave out of every SERP, such as wikipedia.org")
+	var markets = flag.String("markets", "", "comma-separated market IDs to limit rankings to (default all)")
+	var date = flag.String("date", "", "rankings date as YYYY-MM-DD (default every date)")
+	var from = flag.String("from", "", "start of a rankings date range as YYYY-MM-DD")
+	var to = flag.String("to", "", "end of a rankings date range as YYYY-MM-DD")
+	var maxRank = flag.Int("max-rank", 20, "worst average rank for a competitor to be considered")
+	var limit = flag.Int("limit", 20, "maximum number of competitors per SERP")
+	var batchSize = flag.Int("batch-size", 500, "number of keywords to fetch SERPs for per query")
+	var poolSize = flag.Int("pool-size", 5, "maximum number of open database connections")
+	var connLifetime = flag.Duration("conn-lifetime", 30*time.Minute, "maximum time to reuse a database connection (0 for forever)")
+	var statementTimeout = flag.Duration("statement-timeout", 0, "maximum time for a single query (0 for no limit)")
+	var retries = flag.Int("retries", 3, "number of times to retry a query failing with a transient error")
+	var retryBackoff = flag.Duration("retry-backoff", 500*time.Millisecond, "wait before the first retry, doubling for each retry after")
+	var skipFailed = flag.Bool("skip-failed", false, "cluster without keywords whose SERPs could not be fetched instead of exiting")
+	var cacheDir = flag.String("cache", "", "directory to cache fetched SERPs in, keyed by database, such as ~/.cache/keyword-cluster-finder (default no caching)")
+	var cacheTTL = flag.Duration("cache-ttl", 24*time.Hour, "how long cached SERPs stay fresh")
+	var refresh = flag.Bool("refresh", false, "ignore cached SERPs and fetch everything again")
+	var offline = flag.Bool("offline", false, "only use cached SERPs, never connecting to the database")
 	flag.Parse()
 
 	if *domainID == 0 {
 		log.Fatalf("must provide a domain ID")
 	}
-	if *configPath == "" {
-		log.Fatalf("must provide config path")
+	if *offline && (*refresh || *cacheDir == "") {
+		log.Fatalf("-offline requires a cache and cannot be combined with -refresh")
+	}
+	if *datasetPath != "" && (*offline || *queriesDir != "") {
+		log.Fatalf("-dataset cannot be combined with -offline or -queries")
+	}
+	if *saveResults && (*datasetPath != "" || *offline) {
+		log.Fatalf("-save-results requires a database connection")
 	}
 
-	fmt.Println("parsing config...")
-	conf, err := parseConfig(*configPath)
+	observer, err := progress.New(*progressKind)
 	if err != nil {
-		log.Fatalf("could not load config: %v", err)
+		log.Fatalf("invalid -progress: %v", err)
 	}
 
-	fmt.Println()
-	fmt.Println("connecting to database...")
-	driver, err := data.New(
-		data.WithUserAndPass(conf.DB.User, conf.DB.Pass),
-		data.WithHost(conf.DB.Host),
-		data.WithDatabase(conf.DB.Database),
-	)
+	generator, err := candidates.NewFromFlags(*candidateKind, *minShared, *maxDomainShare)
 	if err != nil {
-		log.Fatalf("could not set up database connection: %v", err)
+		log.Fatalf("invalid candidate options: %v", err)
+	}
+
+	opts, err := cli.QueryOptions(*markets, *date, *from, *to, *maxRank, *limit)
+	if err != nil {
+		log.Fatalf("invalid query options: %v", err)
+	}
+
+	templates, namespace := data.DefaultTemplates(), ""
+	if *queriesDir != "" {
+		templates, err = data.LoadTemplates(*queriesDir)
+		if err != nil {
+			log.Fatalf("could not load query templates: %v", err)
+		}
+		namespace = templates.Name
 	}
 
+	var source data.Source
+	var driver *data.Driver
+	var conf data.Config
+	if *datasetPath != "" {
+		// A local dataset is quick to query, so it isn't worth caching
+		store, err := sqlite.Open(*datasetPath, sqlite.WithBatchSize(*batchSize))
+		if err != nil {
+			log.Fatalf("could not open dataset: %v", err)
+		}
+		defer store.Close()
+		source = store
+		*cacheDir = ""
+	} else {
+		// Even offline, the config tells the cache which database to read
+		conf, err = cli.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("could not load config: %v", err)
+		}
+	}
+	if *datasetPath == "" && !*offline {
+		fmt.Println()
+		fmt.Println("connecting to database...")
+		driver, err = data.New(append(conf.Options(),
+			data.WithBatchSize(*batchSize),
+			data.WithPoolSize(*poolSize),
+			data.WithConnMaxLifetime(*connLifetime),
+			data.WithStatementTimeout(*statementTimeout),
+			data.WithRetryPolicy(cli.RetryPolicy(*retries, *retryBackoff)),
+			data.WithTemplates(templates),
+		)...)
+		if err != nil {
+			log.Fatalf("could not set up database connection: %v", err)
+		}
+		defer driver.Close()
+		source = driver
+	}
+
+	if *cacheDir != "" {
+		mode := cache.Normal
+		if *refresh {
+			mode = cache.Refresh
+		} else if *offline {
+			mode = cache.Offline
+		}
+
+		identity, err := conf.Identity()
+		if err != nil {
+			log.Fatalf("invalid connection settings: %v", err)
+		}
+		c, err := cache.New(*cacheDir,
+			cache.WithTTL(*cacheTTL),
+			cache.WithMode(mode),
+			cache.WithNamespace(namespace),
+			cache.WithSource(identity),
+		)
+		if err != nil {
+			log.Fatalf("could not set up cache: %v", err)
+		}
+		source = cache.NewSource(c, source)
+	}
+
+	// Ctrl-C cancels queries in flight rather than leaving them running on
+	// the database
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		fmt.Println()
+		fmt.Println("interrupted, cancelling queries...")
+		cancel()
+	}()
+
 	fmt.Println()
 	fmt.Println("fetching keywords...")
-	keywords, err := driver.FetchKeywords(*domainID)
+	keywords, err := source.FetchKeywords(ctx, *domainID, opts)
 	if err != nil {
 		log.Fatalf("could not read keywords: %v", err)
 	}
 	fmt.Printf("got %d keywords\n\n", len(keywords))
 
 	fmt.Println("querying database...")
-	bar := pb.StartNew(len(keywords))
 
 	kd := rankings.New()
-	err = kd.BuildFromDatabase(driver, *domainID, keywords, bar)
-	if err != nil {
+	policy := rankings.Collect
+	if *skipFailed {
+		policy = rankings.Skip
+	}
+	_, err = kd.BuildFromDatabase(ctx, source, *domainID, keywords, opts, observer, rankings.WithErrorPolicy(policy))
+	if buildErr, ok := err.(rankings.BuildError); ok {
+		fmt.Printf("\n%d keyword(s) permanently failed:\n", len(buildErr.Errors))
+		for _, e := range buildErr.Errors {
+			fmt.Printf("\t%v\n", e)
+		}
+		if !buildErr.Skipped {
+			log.Fatalf("could not build from database; use -skip-failed to cluster without them")
+		}
+	} else if err != nil {
 		log.Fatalf("could not build from database: %v", err)
 	}
-	bar.Finish()
 
-	g := graph.New(
+	if *snapshotPath != "" {
+		ds := snapshot.Dataset{DomainID: *domainID}
+		if len(opts.MarketIDs) == 1 {
+			ds.MarketID = opts.MarketIDs[0]
+		}
+		// A range is dated by its end, so volatility is measured between the
+		// last rankings each snapshot covers
+		snapshotDate := time.Now()
+		if !opts.Date.IsZero() {
+			snapshotDate = opts.Date
+		} else if !opts.To.IsZero() {
+			snapshotDate = opts.To
+		}
+
+		err = recordSnapshot(*snapshotPath, ds, snapshotDate, kd)
+		if err != nil {
+			log.Fatalf("could not record snapshot: %v", err)
+		}
+	}
+
+	graphOptions := []graph.Option{
 		graph.WithRBOPValue(*p),
 		graph.WithClusterPower(*pow),
 		graph.WithClusterInflation(*inf),
 		graph.WithClusterMaxIterations(100),
-	)
+		graph.WithSecondaryThreshold(*secondary),
+		graph.WithCandidates(generator),
+		graph.WithProgress(observer),
+	}
+	if *idf {
+		graphOptions = append(graphOptions, graph.WithIDF())
+	}
+	if domains := cli.SplitList(*stoplist); len(domains) > 0 {
+		graphOptions = append(graphOptions, graph.WithStoplist(domains...))
+	}
+	g := graph.New(graphOptions...)
 	fmt.Println()
 	fmt.Println("finding graph...")
 	clusters, err := g.FindClusters(kd)
@@ -83,33 +247,72 @@ func main() {
 		log.Fatalf("could not find graph clusters: %v", err)
 	}
 
-	for _, cluster := range clusters {
-		fmt.Printf("Cluster: '%s'\n", cluster.Name)
-		for _, kw := range cluster.Keywords {
-			fmt.Printf("\t%s\n", kw)
+	output := g.NewOutput(clusters)
+	var previous *graph.Output
+	if *previousPath != "" {
+		previous, err = graph.LoadOutputFile(*previousPath)
+		if err != nil {
+			log.Fatalf("could not load previous output: %v", err)
 		}
 	}
-}
+	mapping := output.AssignIDs(previous)
 
-func parseConfig(path string) (config, error) {
-	var c config
-	f, err := os.Open(path)
-	if err != nil {
-		return c, fmt.Errorf("could not open file: %v", err)
+	graph.WriteText(os.Stdout, output.Clusters)
+	if previous != nil {
+		fmt.Println()
+		fmt.Println("changes since previous run:")
+		mapping.WriteText(os.Stdout)
 	}
-	defer f.Close()
 
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
-		return c, fmt.Errorf("could not read file: %v", err)
+	if *outPath != "" {
+		err = output.SaveFile(*outPath)
+		if err != nil {
+			log.Fatalf("could not save output: %v", err)
+		}
 	}
 
-	err = json.Unmarshal(data, &c)
+	if *saveResults {
+		run, err := newRun(*domainID, opts, output)
+		if err != nil {
+			log.Fatalf("could not save results: %v", err)
+		}
+		runID, err := driver.SaveRun(ctx, run)
+		if err != nil {
+			log.Fatalf("could not save results: %v", err)
+		}
+		fmt.Println()
+		fmt.Printf("saved results as run %d\n", runID)
+	}
+}
+
+// newRun converts clustering output to a run to record in the database
+func newRun(domainID int, opts data.QueryOptions, output *graph.Output) (data.Run, error) {
+	parameters, err := json.Marshal(output.Parameters)
 	if err != nil {
-		return c, fmt.Errorf("could not parse config: %v", err)
+		return data.Run{}, fmt.Errorf("could not encode parameters: %v", err)
+	}
+	run := data.Run{
+		DomainID:   domainID,
+		Created:    output.Created,
+		Parameters: parameters,
+		Query:      opts,
 	}
 
-	return c, nil
+	for _, c := range output.Clusters {
+		result := data.ClusterResult{
+			ID:         c.ID,
+			Name:       c.Name,
+			Keywords:   c.Keywords,
+			Cohesion:   c.Cohesion,
+			Volatility: c.Volatility,
+		}
+		for _, m := range c.Secondary {
+			result.Secondary = append(result.Secondary, data.SecondaryMember{Keyword: m.Keyword, Affinity: m.Affinity})
+		}
+		run.Clusters = append(run.Clusters, result)
+	}
+
+	return run, nil
 }
 
 func readKeywords(path string) ([]string, error) {
@@ -132,11 +335,12 @@ func readKeywords(path string) ([]string, error) {
 	return keywords, nil
 }
 
-type config struct {
-	DB struct {
-		User     string `json:"user"`
-		Pass     string `json:"pass"`
-		Host     string `json:"host"`
-		Database string `json:"database"`
-	} `json:"db"`
+func recordSnapshot(path string, ds snapshot.Dataset, date time.Time, kd rankings.KeywordData) error {
+	store, err := snapshot.Open(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.Save(ds, date, kd)
 }
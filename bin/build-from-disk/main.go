@@ -1,11 +1,18 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/thedahv/keyword-cluster-finder/pkg/candidates"
+	"github.com/thedahv/keyword-cluster-finder/pkg/cli"
 	"github.com/thedahv/keyword-cluster-finder/pkg/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
 	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
 )
 
@@ -13,33 +20,286 @@ const rboPValue = 0.9
 
 // Use data from ../../pkg/rankings/test-data for sample input
 func main() {
-	args := os.Args[1:]
+	var previousPath = flag.String("previous", "", "saved output of a previous run to carry cluster IDs from, or a directory of them with -domains")
+	var outPath = flag.String("out", "", "path to save the cluster output to, or a directory to save each domain's output to with -domains")
+	var progressKind = flag.String("progress", "bar", "how to report progress: bar, log or none")
+	var candidateKind = flag.String("candidates", "all", "which keyword pairs to compute similarity for: all, index to only compare pairs sharing domains, or minhash to only compare pairs likely to be similar")
+	var minShared = flag.Int("min-shared", 1, "with -candidates index, how many domains two SERPs must share to be compared")
+	var maxDomainShare = flag.Float64("max-domain-share", 0, "with -candidates index, ignore domains ranking for more than this share of keywords (default 0, use every domain)")
+	var idf = flag.Bool("idf", false, "weight domains by inverse document frequency, so ones ranking for most keywords link them less")
+	var stoplist = flag.String("stoplist", "", "comma-separated domains to leave out of every SERP, such as wikipedia.org")
+	var concurrency = flag.Int("concurrency", 0, "maximum number of files to load at once (default the number of CPUs)")
+	var onError = flag.String("on-error", "collect", "what to do with files that fail to load: fail-fast, skip them, or collect every failure before exiting")
+	var recursive = flag.Bool("recursive", false, "load files in subdirectories too")
+	var include = flag.String("include", "*.json", "comma-separated glob patterns of files to load")
+	var exclude = flag.String("exclude", "", "comma-separated glob patterns of files and folders to leave out")
+	var domains = flag.Bool("domains", false, "treat each top-level subfolder as a separate domain and cluster each one")
+	var saveRankings = flag.String("save-rankings", "", "path to save the loaded rankings to in binary form, for faster loading next time")
+	var saveSimilarity = flag.String("save-similarity", "", "path to save the computed similarity matrix to")
+	var loadSimilarity = flag.String("load-similarity", "", "path of a saved similarity matrix to cluster instead of computing one")
+	var incremental = flag.Bool("incremental", false, "update the -previous clusters for keywords added or removed since, using the matrix from -load-similarity")
+	flag.Parse()
+	args := flag.Args()
 
-	if len(args) == 0 {
-		log.Fatal("rankings directory argument required")
+	if len(args) == 0 && *loadSimilarity == "" {
+		log.Fatal("rankings directory, bundle or binary file argument required")
+	}
+	if len(args) == 0 && (*saveRankings != "" || *saveSimilarity != "") {
+		log.Fatal("rankings argument required to save rankings or similarity")
+	}
+	if *incremental && (len(args) == 0 || *loadSimilarity == "" || *previousPath == "") {
+		log.Fatal("-incremental requires rankings, -load-similarity and -previous")
+	}
+	if *domains && (*saveRankings != "" || *saveSimilarity != "" || *loadSimilarity != "" || *incremental) {
+		log.Fatal("-domains cannot be combined with saving or loading binary files")
+	}
+
+	observer, err := progress.New(*progressKind)
+	if err != nil {
+		log.Fatalf("invalid -progress: %v", err)
 	}
 
-	directory := args[0]
-	kd, err := rankings.ProcessDirectory(directory)
+	generator, err := candidates.NewFromFlags(*candidateKind, *minShared, *maxDomainShare)
 	if err != nil {
-		log.Fatalf("could not process directory: %v", err)
+		log.Fatalf("invalid candidate options: %v", err)
+	}
+
+	policy, err := rankings.ParseErrorPolicy(*onError)
+	if err != nil {
+		log.Fatalf("invalid -on-error: %v", err)
+	}
+
+	options := []rankings.LoadOption{
+		rankings.WithConcurrency(*concurrency),
+		rankings.WithProgress(observer),
+		rankings.WithErrorPolicy(policy),
+		rankings.WithInclude(cli.SplitList(*include)...),
+		rankings.WithExclude(cli.SplitList(*exclude)...),
+	}
+	if *recursive {
+		options = append(options, rankings.WithRecursive())
 	}
 
-	g := graph.New(
+	graphOptions := []graph.Option{
 		graph.WithRBOPValue(rboPValue),
 		graph.WithClusterPower(2),
 		graph.WithClusterInflation(5),
 		graph.WithClusterMaxIterations(100),
-	)
-	clusters, err := g.FindClusters(kd)
+		graph.WithCandidates(generator),
+		graph.WithProgress(observer),
+	}
+	if *idf {
+		graphOptions = append(graphOptions, graph.WithIDF())
+	}
+	if domains := cli.SplitList(*stoplist); len(domains) > 0 {
+		graphOptions = append(graphOptions, graph.WithStoplist(domains...))
+	}
+	g := graph.New(graphOptions...)
+
+	if !*domains {
+		var kd rankings.KeywordData
+		if len(args) > 0 {
+			kd, err = rankings.LoadPath(args[0], options...)
+			checkLoad(err)
+		}
+		if *saveRankings != "" {
+			err = createFile(*saveRankings, kd.Save)
+			if err != nil {
+				log.Fatalf("could not save rankings: %v", err)
+			}
+		}
+
+		// An incremental run brings a matrix for older rankings up to date,
+		// so it cannot match the rankings loaded now
+		sim, err := similarity(g, kd, *loadSimilarity, !*incremental)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if *incremental {
+			err = clusterIncrementally(g, kd, sim, *previousPath, *outPath)
+		} else {
+			err = cluster(g, sim, *previousPath, *outPath)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if *saveSimilarity != "" {
+			// The pairs an incremental run kept were computed from older
+			// rankings, so the matrix is only good for further incremental runs
+			header := g.SimilarityHeader(kd)
+			header.Incremental = *incremental
+			err = createFile(*saveSimilarity, func(w io.Writer) error {
+				return sim.Save(w, header)
+			})
+			if err != nil {
+				log.Fatalf("could not save similarity: %v", err)
+			}
+		}
+		return
+	}
+
+	datasets, err := rankings.ProcessDomains(args[0], options...)
+	checkLoad(err)
+
+	for i, d := range datasets {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("domain %s (%d keywords):\n", d.Domain, len(d.Keywords))
+
+		var previous, out string
+		if *previousPath != "" {
+			previous = filepath.Join(*previousPath, d.Domain+".json")
+			if _, err := os.Stat(previous); os.IsNotExist(err) {
+				previous = ""
+			}
+		}
+		if *outPath != "" {
+			err = os.MkdirAll(*outPath, 0755)
+			if err != nil {
+				log.Fatalf("could not create output directory: %v", err)
+			}
+			out = filepath.Join(*outPath, d.Domain+".json")
+		}
+
+		sim, err := g.ComputeSimilarity(d.Keywords)
+		if err == nil {
+			err = cluster(g, sim, previous, out)
+		}
+		if err != nil {
+			log.Fatalf("domain %s: %v", d.Domain, err)
+		}
+	}
+}
+
+// checkLoad exits on a load error, unless the failed files were skipped, in
+// which case they are listed before carrying on
+func checkLoad(err error) {
+	if buildErr, ok := err.(rankings.BuildError); ok && buildErr.Skipped {
+		fmt.Printf("skipped %d file(s):\n", len(buildErr.Errors))
+		for _, e := range buildErr.Errors {
+			fmt.Printf("\t%v\n", e)
+		}
+		fmt.Println()
+	} else if err != nil {
+		log.Fatalf("could not load rankings: %v", err)
+	}
+}
+
+// similarity computes the similarity matrix of kd, or loads the one saved at
+// p if given. A loaded matrix must have been computed with the same parameters
+// and, if matchData is set, not updated incrementally and, if rankings were
+// loaded, from kd
+func similarity(g *graph.Graph, kd rankings.KeywordData, p string, matchData bool) (graph.Similarity, error) {
+	if p == "" {
+		return g.ComputeSimilarity(kd)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not open similarity: %v", err)
+	}
+	defer f.Close()
+
+	sim, header, err := graph.LoadSimilarity(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not load similarity: %v", err)
+	}
+	if kd != nil && matchData {
+		err = header.Check(*g, kd)
+	} else if matchData && header.Incremental {
+		err = fmt.Errorf("matrix was updated incrementally, so use it with -incremental")
+	} else {
+		err = header.CheckParameters(*g)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot use saved similarity: %v", err)
+	}
+	return sim, nil
+}
+
+// clusterIncrementally updates the clusters saved at previousPath for the
+// keywords now in kd, updating sim along the way, and prints them with a
+// report of what changed. The new output is saved to outPath, if given
+func clusterIncrementally(g *graph.Graph, kd rankings.KeywordData, sim graph.Similarity, previousPath, outPath string) error {
+	previous, err := graph.LoadOutputFile(previousPath)
+	if err != nil {
+		return fmt.Errorf("could not load previous output: %v", err)
+	}
+
+	update, err := g.FindClustersIncrementally(kd, sim, previous)
+	if err != nil {
+		return fmt.Errorf("could not update clusters: %v", err)
+	}
+
+	graph.WriteText(os.Stdout, update.Output.Clusters)
+	fmt.Println()
+	fmt.Printf("added %d keyword(s), removed %d\n", len(update.Added), len(update.Removed))
+	fmt.Printf("assigned %d to existing clusters, reclustered %d in affected components\n",
+		len(update.Assigned), len(update.Reclustered))
+	if len(update.Changed) > 0 {
+		fmt.Printf("changed: %s\n", strings.Join(update.Changed, ", "))
+	}
+	fmt.Println()
+	fmt.Println("changes since previous run:")
+	update.Mapping.WriteText(os.Stdout)
+
+	if outPath != "" {
+		err = update.Output.SaveFile(outPath)
+		if err != nil {
+			return fmt.Errorf("could not save output: %v", err)
+		}
+	}
+	return nil
+}
+
+// cluster finds and prints the clusters in the similarity matrix, carrying IDs
+// over from the output saved at previousPath and saving the new output to
+// outPath, if given
+func cluster(g *graph.Graph, sim graph.Similarity, previousPath, outPath string) error {
+	clusters, err := g.ClusterSimilarity(sim)
 	if err != nil {
-		log.Fatalf("could not find graph clusters: %v", err)
+		return fmt.Errorf("could not find graph clusters: %v", err)
 	}
 
-	for _, cluster := range clusters {
-		fmt.Printf("Cluster: '%s'\n", cluster.Name)
-		for _, kw := range cluster.Keywords {
-			fmt.Printf("\t%s\n", kw)
+	output := g.NewOutput(clusters)
+	var previous *graph.Output
+	if previousPath != "" {
+		previous, err = graph.LoadOutputFile(previousPath)
+		if err != nil {
+			return fmt.Errorf("could not load previous output: %v", err)
 		}
 	}
+	mapping := output.AssignIDs(previous)
+
+	graph.WriteText(os.Stdout, output.Clusters)
+	if previous != nil {
+		fmt.Println()
+		fmt.Println("changes since previous run:")
+		mapping.WriteText(os.Stdout)
+	}
+
+	if outPath != "" {
+		err = output.SaveFile(outPath)
+		if err != nil {
+			return fmt.Errorf("could not save output: %v", err)
+		}
+	}
+	return nil
+}
+
+// createFile creates the file at path and writes it with write
+func createFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %v", err)
+	}
+
+	err = write(f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("could not close file: %v", cerr)
+	}
+	return err
 }
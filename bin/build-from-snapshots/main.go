@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/snapshot"
+	"github.com/thedahv/keyword-cluster-finder/pkg/volatility"
+)
+
+// Computes keyword clusters from SERP data recorded in a snapshot store, either
+// for a single date or for each snapshot in a date range
+func main() {
+	// Required
+	var storePath = flag.String("store", "", "snapshot store path")
+	var domainID = flag.Int("domainID", 0, "Domain ID")
+
+	// Optional
+	var marketID = flag.Int("market", 0, "Market ID (0 for all markets)")
+	var date = flag.String("date", "", "snapshot date as YYYY-MM-DD (default latest)")
+	var from = flag.String("from", "", "start of a date range as YYYY-MM-DD")
+	var to = flag.String("to", "", "end of a date range as YYYY-MM-DD")
+	var p = flag.Float64("p", 0.9, "RBO p value")
+	var pow = flag.Int("pow", 5, "Cluster power")
+	var inf = flag.Int("inf", 2, "Cluster inflation")
+	var secondary = flag.Float64("secondary", 0.5, "Minimum affinity for secondary cluster membership (0 disables)")
+	var previousPath = flag.String("previous", "", "saved output of a previous run to carry cluster IDs from")
+	var outPath = flag.String("out", "", "path to save the output of the last clustered date to")
+	flag.Parse()
+
+	if *storePath == "" {
+		log.Fatalf("must provide a store path")
+	}
+	if *domainID == 0 {
+		log.Fatalf("must provide a domain ID")
+	}
+	if *date != "" && (*from != "" || *to != "") {
+		log.Fatalf("use either -date or -from and -to")
+	}
+
+	store, err := snapshot.Open(*storePath)
+	if err != nil {
+		log.Fatalf("could not open store: %v", err)
+	}
+	defer store.Close()
+
+	ds := snapshot.Dataset{DomainID: *domainID, MarketID: *marketID}
+	snaps, err := loadSnapshots(store, ds, *date, *from, *to)
+	if err != nil {
+		log.Fatalf("could not load snapshots: %v", err)
+	}
+	if len(snaps) == 0 {
+		log.Fatalf("no snapshots found for domain %d, market %d", ds.DomainID, ds.MarketID)
+	}
+
+	g := graph.New(
+		graph.WithRBOPValue(*p),
+		graph.WithClusterPower(*pow),
+		graph.WithClusterInflation(*inf),
+		graph.WithClusterMaxIterations(100),
+		graph.WithSecondaryThreshold(*secondary),
+	)
+
+	// With more than one date, score clusters by how much their SERPs churned
+	// over the whole range
+	var analysis *volatility.Analysis
+	if len(snaps) > 1 {
+		a, err := volatility.Analyze(snaps, *p)
+		if err != nil {
+			log.Fatalf("could not compute volatility: %v", err)
+		}
+		analysis = &a
+	}
+
+	var previous *graph.Output
+	if *previousPath != "" {
+		previous, err = graph.LoadOutputFile(*previousPath)
+		if err != nil {
+			log.Fatalf("could not load previous output: %v", err)
+		}
+	}
+
+	// Each date is clustered independently, but cluster IDs are carried from
+	// one date to the next so the same topic keeps the same ID over the range
+	for i, snap := range snaps {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("=== %s (%d keywords) ===\n", snapshot.FormatDate(snap.Date), len(snap.KeywordData))
+
+		clusters, err := g.FindClusters(snap.KeywordData)
+		if err != nil {
+			log.Fatalf("could not find graph clusters for %s: %v", snapshot.FormatDate(snap.Date), err)
+		}
+
+		if analysis != nil {
+			analysis.Apply(clusters)
+		}
+
+		output := g.NewOutput(clusters)
+		mapping := output.AssignIDs(previous)
+		graph.WriteText(os.Stdout, output.Clusters)
+		if previous != nil {
+			fmt.Println()
+			fmt.Println("changes since previous run:")
+			mapping.WriteText(os.Stdout)
+		}
+		previous = output
+	}
+
+	if *outPath != "" {
+		err = previous.SaveFile(*outPath)
+		if err != nil {
+			log.Fatalf("could not save output: %v", err)
+		}
+	}
+}
+
+// loadSnapshots finds the snapshots for a single date, a date range, or the
+// latest date if neither is given
+func loadSnapshots(store *snapshot.Store, ds snapshot.Dataset, date, from, to string) ([]snapshot.Snapshot, error) {
+	if from != "" || to != "" {
+		dates, err := store.Dates(ds)
+		if err != nil {
+			return nil, err
+		}
+		if len(dates) == 0 {
+			return nil, nil
+		}
+
+		start, end := dates[0], dates[len(dates)-1]
+		if from != "" {
+			start, err = snapshot.ParseDate(from)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if to != "" {
+			end, err = snapshot.ParseDate(to)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return store.LoadRange(ds, start, end)
+	}
+
+	if date == "" {
+		dates, err := store.Dates(ds)
+		if err != nil {
+			return nil, err
+		}
+		if len(dates) == 0 {
+			return nil, nil
+		}
+		date = snapshot.FormatDate(dates[len(dates)-1])
+	}
+
+	d, err := snapshot.ParseDate(date)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := store.Load(ds, d)
+	if err != nil {
+		return nil, err
+	}
+	return []snapshot.Snapshot{snap}, nil
+}
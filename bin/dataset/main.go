@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/sqlite"
+)
+
+// Lists the domains in a SQLite SERP dataset, or imports a directory of SERP
+// data into one
+func main() {
+	var datasetPath = flag.String("dataset", "", "SQLite dataset path")
+	var domainID = flag.Int("domainID", 0, "Domain ID")
+	var marketID = flag.Int("market", 0, "Market ID the SERPs were captured for")
+	var date = flag.String("date", "", "date the SERPs were captured as YYYY-MM-DD (default today)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: dataset [flags] list")
+		fmt.Fprintln(flag.CommandLine.Output(), "       dataset [flags] import <directory|bundle|binary>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+
+	if *datasetPath == "" {
+		log.Fatalf("must provide a dataset path")
+	}
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store, err := sqlite.Open(*datasetPath)
+	if err != nil {
+		log.Fatalf("could not open dataset: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "list":
+		err = list(ctx, store)
+	case "import":
+		if len(args) != 2 {
+			log.Fatalf("import requires a directory or bundle argument")
+		}
+		if *domainID == 0 {
+			log.Fatalf("must provide a domain ID")
+		}
+		err = importRankings(ctx, store, *domainID, *marketID, *date, args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("could not %s: %v", args[0], err)
+	}
+}
+
+// list prints each domain in the dataset with its markets and dates
+func list(ctx context.Context, store *sqlite.Store) error {
+	domains, err := store.Domains(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range domains {
+		var markets, dates []string
+		for _, m := range d.Markets {
+			markets = append(markets, fmt.Sprint(m))
+		}
+		for _, t := range d.Dates {
+			dates = append(dates, t.Format(sqlite.DateFormat))
+		}
+		fmt.Printf("domain %d: %d keywords\n", d.DomainID, d.Keywords)
+		fmt.Printf("\tmarkets: %s\n", strings.Join(markets, ", "))
+		fmt.Printf("\tdates: %s\n", strings.Join(dates, ", "))
+	}
+
+	return nil
+}
+
+func importRankings(ctx context.Context, store *sqlite.Store, domainID, marketID int, date string, p string) error {
+	d := time.Now()
+	if date != "" {
+		var err error
+		d, err = time.Parse(sqlite.DateFormat, date)
+		if err != nil {
+			return fmt.Errorf("could not parse date: %v", err)
+		}
+	}
+
+	kd, err := rankings.LoadPath(p)
+	if err != nil {
+		return fmt.Errorf("could not load rankings: %v", err)
+	}
+
+	err = store.Import(ctx, domainID, marketID, d, kd)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d keywords for %s\n", len(kd), d.Format(sqlite.DateFormat))
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// Compares two clustering runs. Each argument is either an output saved with
+// the -out flag of build-from-disk or build-from-db, or a directory of SERP
+// data that is clustered with the parameters given as flags
+func main() {
+	var format = flag.String("format", "text", "output format: text or json")
+	var p = flag.Float64("p", 0.9, "RBO p value for directory inputs")
+	var pow = flag.Int("pow", 2, "Cluster power for directory inputs")
+	var inf = flag.Int("inf", 5, "Cluster inflation for directory inputs")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) != 2 {
+		log.Fatal("usage: diff-clusters [flags] <before> <after>")
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format '%s'", *format)
+	}
+
+	g := graph.New(
+		graph.WithRBOPValue(*p),
+		graph.WithClusterPower(*pow),
+		graph.WithClusterInflation(*inf),
+		graph.WithClusterMaxIterations(100),
+	)
+
+	before, err := load(g, args[0])
+	if err != nil {
+		log.Fatalf("could not load %s: %v", args[0], err)
+	}
+	after, err := load(g, args[1])
+	if err != nil {
+		log.Fatalf("could not load %s: %v", args[1], err)
+	}
+	if before.Parameters != after.Parameters {
+		fmt.Fprintf(os.Stderr, "warning: runs used different parameters: %+v vs %+v\n",
+			before.Parameters, after.Parameters)
+	}
+
+	diff := graph.Compare(before, after)
+	if *format == "json" {
+		err = diff.WriteJSON(os.Stdout)
+		if err != nil {
+			log.Fatalf("could not write diff: %v", err)
+		}
+		fmt.Println()
+		return
+	}
+	diff.WriteText(os.Stdout)
+}
+
+// load reads a saved output, or clusters a directory of SERP data
+func load(g *graph.Graph, path string) (*graph.Output, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return graph.LoadOutputFile(path)
+	}
+
+	kd, err := rankings.ProcessDirectory(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not process directory: %v", err)
+	}
+	clusters, err := g.FindClusters(kd)
+	if err != nil {
+		return nil, fmt.Errorf("could not find graph clusters: %v", err)
+	}
+
+	output := g.NewOutput(clusters)
+	output.AssignIDs(nil)
+	return output, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/cache"
+	"github.com/thedahv/keyword-cluster-finder/pkg/cli"
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// Fetches SERP data from the product database and saves it in the layout read
+// by build-from-disk, so the clusters can be reproduced without database
+// access
+func main() {
+	// Required
+	var domainID = flag.Int("domainID", 0, "Domain ID")
+
+	// Output, one of which is required
+	var outDir = flag.String("out", "", "directory to write one JSON file per keyword to")
+	var bundlePath = flag.String("bundle", "", "file to write all SERPs to as a single JSON bundle")
+
+	// Optional
+	var configPath = flag.String("config", "", "app JSON config (default connects using PG* environment variables)")
+	var queriesDir = flag.String("queries", "", "directory of SQL query templates for a different warehouse schema (default our product database)")
+	var progressKind = flag.String("progress", "bar", "how to report progress: bar, log or none")
+	var markets = flag.String("markets", "", "comma-separated market IDs to limit rankings to (default all)")
+	var date = flag.String("date", "", "rankings date as YYYY-MM-DD (default every date)")
+	var from = flag.String("from", "", "start of a rankings date range as YYYY-MM-DD")
+	var to = flag.String("to", "", "end of a rankings date range as YYYY-MM-DD")
+	var maxRank = flag.Int("max-rank", 20, "worst average rank for a competitor to be considered")
+	var limit = flag.Int("limit", 20, "maximum number of competitors per SERP")
+	var batchSize = flag.Int("batch-size", 500, "number of keywords to fetch SERPs for per query")
+	var retries = flag.Int("retries", 3, "number of times to retry a query failing with a transient error")
+	var retryBackoff = flag.Duration("retry-backoff", 500*time.Millisecond, "wait before the first retry, doubling for each retry after")
+	var cacheDir = flag.String("cache", "", "directory to cache fetched SERPs in, keyed by database, such as ~/.cache/keyword-cluster-finder (default no caching)")
+	var refresh = flag.Bool("refresh", false, "ignore cached SERPs and fetch everything again")
+	flag.Parse()
+
+	if *domainID == 0 {
+		log.Fatalf("must provide a domain ID")
+	}
+	if (*outDir == "") == (*bundlePath == "") {
+		log.Fatalf("must provide exactly one of -out or -bundle")
+	}
+
+	observer, err := progress.New(*progressKind)
+	if err != nil {
+		log.Fatalf("invalid -progress: %v", err)
+	}
+
+	opts, err := cli.QueryOptions(*markets, *date, *from, *to, *maxRank, *limit)
+	if err != nil {
+		log.Fatalf("invalid query options: %v", err)
+	}
+
+	templates, namespace := data.DefaultTemplates(), ""
+	if *queriesDir != "" {
+		templates, err = data.LoadTemplates(*queriesDir)
+		if err != nil {
+			log.Fatalf("could not load query templates: %v", err)
+		}
+		namespace = templates.Name
+	}
+
+	conf, err := cli.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("could not load config: %v", err)
+	}
+	driver, err := data.New(append(conf.Options(),
+		data.WithBatchSize(*batchSize),
+		data.WithRetryPolicy(cli.RetryPolicy(*retries, *retryBackoff)),
+		data.WithTemplates(templates),
+	)...)
+	if err != nil {
+		log.Fatalf("could not set up database connection: %v", err)
+	}
+	defer driver.Close()
+
+	var source data.Source = driver
+	if *cacheDir != "" {
+		mode := cache.Normal
+		if *refresh {
+			mode = cache.Refresh
+		}
+		identity, err := conf.Identity()
+		if err != nil {
+			log.Fatalf("invalid connection settings: %v", err)
+		}
+		c, err := cache.New(*cacheDir, cache.WithMode(mode), cache.WithNamespace(namespace), cache.WithSource(identity))
+		if err != nil {
+			log.Fatalf("could not set up cache: %v", err)
+		}
+		source = cache.NewSource(c, source)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		fmt.Println()
+		fmt.Println("interrupted, cancelling queries...")
+		cancel()
+	}()
+
+	fmt.Println("fetching keywords...")
+	keywords, err := source.FetchKeywords(ctx, *domainID, opts)
+	if err != nil {
+		log.Fatalf("could not read keywords: %v", err)
+	}
+	fmt.Printf("got %d keywords\n\n", len(keywords))
+
+	fmt.Println("querying database...")
+	manifest := rankings.Manifest{
+		DomainID:  *domainID,
+		FetchedAt: time.Now().UTC(),
+		Query:     opts,
+	}
+	kd := rankings.New()
+	report, err := kd.BuildFromDatabase(ctx, source, *domainID, keywords, opts, observer)
+	if _, ok := err.(rankings.BuildError); ok {
+		manifest.Failed = report.FailedKeywords()
+		fmt.Printf("\n%d keyword(s) permanently failed and are listed in the manifest\n", len(manifest.Failed))
+	} else if err != nil {
+		log.Fatalf("could not build from database: %v", err)
+	}
+
+	if *outDir != "" {
+		err = kd.WriteDirectory(*outDir, manifest)
+		if err != nil {
+			log.Fatalf("could not write SERPs: %v", err)
+		}
+		fmt.Printf("wrote %d SERPs to %s\n", len(kd), *outDir)
+		return
+	}
+
+	err = kd.WriteBundleFile(*bundlePath, manifest)
+	if err != nil {
+		log.Fatalf("could not write bundle: %v", err)
+	}
+	fmt.Printf("wrote %d SERPs to %s\n", len(kd), *bundlePath)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/snapshot"
+)
+
+// Lists the snapshots in a store, or imports a directory of SERP data as a
+// dated snapshot
+func main() {
+	var storePath = flag.String("store", "", "snapshot store path")
+	var domainID = flag.Int("domainID", 0, "Domain ID")
+	var marketID = flag.Int("market", 0, "Market ID (0 for all markets)")
+	var date = flag.String("date", "", "snapshot date as YYYY-MM-DD (default today)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: snapshots [flags] list")
+		fmt.Fprintln(flag.CommandLine.Output(), "       snapshots [flags] import <directory>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+
+	if *storePath == "" {
+		log.Fatalf("must provide a store path")
+	}
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store, err := snapshot.Open(*storePath)
+	if err != nil {
+		log.Fatalf("could not open store: %v", err)
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "list":
+		err = list(store, *domainID)
+	case "import":
+		if len(args) != 2 {
+			log.Fatalf("import requires a directory argument")
+		}
+		if *domainID == 0 {
+			log.Fatalf("must provide a domain ID")
+		}
+		err = importDirectory(store, snapshot.Dataset{DomainID: *domainID, MarketID: *marketID}, *date, args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("could not %s: %v", args[0], err)
+	}
+}
+
+// list prints each dataset in the store along with its snapshot dates
+func list(store *snapshot.Store, domainID int) error {
+	datasets, err := store.Datasets()
+	if err != nil {
+		return err
+	}
+
+	for _, ds := range datasets {
+		if domainID != 0 && ds.DomainID != domainID {
+			continue
+		}
+
+		dates, err := store.Dates(ds)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("domain %d, market %d:\n", ds.DomainID, ds.MarketID)
+		for _, d := range dates {
+			fmt.Printf("\t%s\n", snapshot.FormatDate(d))
+		}
+	}
+
+	return nil
+}
+
+func importDirectory(store *snapshot.Store, ds snapshot.Dataset, date string, directory string) error {
+	d := time.Now()
+	if date != "" {
+		var err error
+		d, err = snapshot.ParseDate(date)
+		if err != nil {
+			return err
+		}
+	}
+
+	kd, err := rankings.ProcessDirectory(directory)
+	if err != nil {
+		return fmt.Errorf("could not process directory: %v", err)
+	}
+
+	err = store.Save(ds, d, kd)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d keywords for %s\n", len(kd), snapshot.FormatDate(d))
+	return nil
+}
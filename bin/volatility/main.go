@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/snapshot"
+	"github.com/thedahv/keyword-cluster-finder/pkg/volatility"
+)
+
+// Measures how much SERPs churn between consecutive snapshots, per keyword, per
+// date and, given a saved cluster output, per cluster
+func main() {
+	// Required
+	var storePath = flag.String("store", "", "snapshot store path")
+	var domainID = flag.Int("domainID", 0, "Domain ID")
+
+	// Optional
+	var marketID = flag.Int("market", 0, "Market ID (0 for all markets)")
+	var from = flag.String("from", "", "start of the date range as YYYY-MM-DD (default first snapshot)")
+	var to = flag.String("to", "", "end of the date range as YYYY-MM-DD (default last snapshot)")
+	var p = flag.Float64("p", 0.9, "RBO p value")
+	var top = flag.Int("top", 20, "number of most volatile keywords to list (0 for all)")
+	var clustersPath = flag.String("clusters", "", "saved cluster output to score")
+	var outPath = flag.String("out", "", "path to save the scored cluster output to")
+	var format = flag.String("format", "text", "output format: text or json")
+	flag.Parse()
+
+	if *storePath == "" {
+		log.Fatalf("must provide a store path")
+	}
+	if *domainID == 0 {
+		log.Fatalf("must provide a domain ID")
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format '%s'", *format)
+	}
+
+	store, err := snapshot.Open(*storePath)
+	if err != nil {
+		log.Fatalf("could not open store: %v", err)
+	}
+	defer store.Close()
+
+	ds := snapshot.Dataset{DomainID: *domainID, MarketID: *marketID}
+	dates, err := store.Dates(ds)
+	if err != nil {
+		log.Fatalf("could not list snapshots: %v", err)
+	}
+	if len(dates) < 2 {
+		log.Fatalf("need at least 2 snapshots, found %d", len(dates))
+	}
+	start, end := dates[0], dates[len(dates)-1]
+	if *from != "" {
+		start, err = snapshot.ParseDate(*from)
+		if err != nil {
+			log.Fatalf("invalid -from: %v", err)
+		}
+	}
+	if *to != "" {
+		end, err = snapshot.ParseDate(*to)
+		if err != nil {
+			log.Fatalf("invalid -to: %v", err)
+		}
+	}
+
+	snaps, err := store.LoadRange(ds, start, end)
+	if err != nil {
+		log.Fatalf("could not load snapshots: %v", err)
+	}
+	analysis, err := volatility.Analyze(snaps, *p)
+	if err != nil {
+		log.Fatalf("could not compute volatility: %v", err)
+	}
+
+	var output *graph.Output
+	if *clustersPath != "" {
+		output, err = graph.LoadOutputFile(*clustersPath)
+		if err != nil {
+			log.Fatalf("could not load clusters: %v", err)
+		}
+		analysis.Apply(output.Clusters)
+	}
+
+	ranked := analysis.Ranked()
+	if *top > 0 && len(ranked) > *top {
+		ranked = ranked[:*top]
+	}
+
+	if *format == "json" {
+		report := struct {
+			Timeline []volatility.Point   `json:"timeline"`
+			Keywords []volatility.Score   `json:"keywords"`
+			Clusters []graph.ClusterGroup `json:"clusters,omitempty"`
+		}{Timeline: analysis.Timeline, Keywords: ranked}
+		if output != nil {
+			report.Clusters = output.Clusters
+		}
+
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("could not encode report: %v", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		fmt.Println("volatility by date:")
+		for _, pt := range analysis.Timeline {
+			fmt.Printf("\t%s: %.3f (%d keywords)\n", snapshot.FormatDate(pt.Date), pt.Volatility, pt.Keywords)
+		}
+
+		fmt.Println()
+		fmt.Println("most volatile keywords:")
+		for _, s := range ranked {
+			fmt.Printf("\t%s: %.3f (%d changes)\n", s.Keyword, s.Volatility, s.Changes)
+		}
+
+		if output != nil {
+			fmt.Println()
+			fmt.Println("volatility by cluster:")
+			for _, c := range output.Clusters {
+				if c.Volatility == nil {
+					fmt.Printf("\t%s '%s': not measured\n", c.ID, c.Name)
+					continue
+				}
+				fmt.Printf("\t%s '%s': %.3f\n", c.ID, c.Name, *c.Volatility)
+			}
+		}
+	}
+
+	if *outPath != "" {
+		if output == nil {
+			log.Fatalf("-out requires -clusters")
+		}
+		err = output.SaveFile(*outPath)
+		if err != nil {
+			log.Fatalf("could not save output: %v", err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ module github.com/thedahv/keyword-cluster-finder
 go 1.14
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/cheggaaa/pb v2.0.7+incompatible
 	github.com/cheggaaa/pb/v3 v3.0.4 // indirect
 	github.com/ckaznocha/protoc-gen-lint v0.2.1 // indirect
@@ -21,8 +22,10 @@ require (
 	github.com/jamesneve/go-markov-cluster v0.0.0-20170531101157-fbab162b0e2b
 	github.com/koron/iferr v0.0.0-20180615142939-bb332a3b1d91 // indirect
 	github.com/lib/pq v1.7.1
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/rogpeppe/godef v1.1.2 // indirect
 	github.com/stamblerre/gocode v1.0.0 // indirect
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/tools/gopls v0.4.3 // indirect
 	google.golang.org/grpc v1.30.0 // indirect
 	google.golang.org/grpc/examples v0.0.0-20200720210446-ca3959a1b21a // indirect
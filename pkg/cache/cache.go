@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+)
+
+// ErrMiss is returned when an entry is not in the cache, has expired, or is
+// being refreshed
+var ErrMiss = errors.New("not in cache")
+
+// Mode controls when the cache is used
+type Mode int
+
+const (
+	// Normal reads fresh entries from the cache and fetches the rest
+	Normal Mode = iota
+	// Refresh ignores cached entries, fetching and caching everything again
+	Refresh
+	// Offline only reads from the cache, even if entries have expired, and
+	// never fetches
+	Offline
+)
+
+// Cache stores entries as JSON files on disk, named after the hash of their
+// key
+type Cache struct {
+	dir       string
+	ttl       time.Duration
+	mode      Mode
+	namespace string
+	source    string
+	now       func() time.Time
+}
+
+// Option configures a Cache
+type Option func(*Cache)
+
+// WithTTL configures how long entries stay fresh. Zero means entries never
+// expire
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+// WithMode configures when the cache is used
+func WithMode(m Mode) Option {
+	return func(c *Cache) {
+		c.mode = m
+	}
+}
+
+// WithNamespace keeps the cache's entries apart from those of caches in other
+// namespaces sharing the directory, such as data fetched with different query
+// templates
+func WithNamespace(namespace string) Option {
+	return func(c *Cache) {
+		c.namespace = namespace
+	}
+}
+
+// WithSource keeps the cache's entries apart from those fetched from other
+// databases sharing the directory. source identifies the database, as given by
+// data.Config.Identity
+func WithSource(source string) Option {
+	return func(c *Cache) {
+		c.source = source
+	}
+}
+
+// New creates a cache storing entries in dir, creating it if necessary
+func New(dir string, options ...Option) (*Cache, error) {
+	c := &Cache{
+		dir: dir,
+		ttl: 24 * time.Hour,
+		now: time.Now,
+	}
+	for _, o := range options {
+		o(c)
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cache directory: %v", err)
+	}
+
+	return c, nil
+}
+
+// Key identifies a cache entry. Two keys with the same fields address the same
+// entry
+type Key struct {
+	// Namespace and Source are set from the cache the entry is stored in
+	Namespace string `json:"namespace,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Kind      string `json:"kind"`
+	DomainID  int    `json:"domain_id"`
+	Keyword   string `json:"keyword,omitempty"`
+	Markets   []int  `json:"markets,omitempty"`
+	// Date is the rankings date or range, or "latest". The latest rankings
+	// change over time, so rely on the TTL to refetch them
+	Date    string `json:"date"`
+	MaxRank int    `json:"max_rank,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// entry is the stored form of a cached value
+type entry struct {
+	Key    Key             `json:"key"`
+	Stored time.Time       `json:"stored"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// Get reads the entry for key into value. It returns ErrMiss if there is no
+// usable entry
+func (c *Cache) Get(key Key, value interface{}) error {
+	if c.mode == Refresh {
+		return ErrMiss
+	}
+
+	raw, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return ErrMiss
+	}
+	if err != nil {
+		return fmt.Errorf("could not read cache entry: %v", err)
+	}
+
+	var e entry
+	err = json.Unmarshal(raw, &e)
+	if err != nil {
+		return fmt.Errorf("could not parse cache entry: %v", err)
+	}
+	if c.mode != Offline && c.ttl > 0 && c.now().Sub(e.Stored) > c.ttl {
+		return ErrMiss
+	}
+
+	err = json.Unmarshal(e.Value, value)
+	if err != nil {
+		return fmt.Errorf("could not parse cached value: %v", err)
+	}
+	return nil
+}
+
+// Put stores value as the entry for key
+func (c *Cache) Put(key Key, value interface{}) error {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("could not encode cache value: %v", err)
+	}
+	key.Namespace, key.Source = c.namespace, c.source
+	raw, err := json.Marshal(entry{Key: key, Stored: c.now(), Value: v})
+	if err != nil {
+		return fmt.Errorf("could not encode cache entry: %v", err)
+	}
+
+	p := c.path(key)
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create cache directory: %v", err)
+	}
+
+	// Write to a temporary file and rename it into place so readers never see
+	// a partially written entry
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("could not create cache entry: %v", err)
+	}
+	_, err = tmp.Write(raw)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("could not write cache entry: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+// path finds the file for key. Entries are spread over subdirectories named
+// after the first byte of their hash to keep directories small
+func (c *Cache) path(key Key) string {
+	key.Namespace, key.Source = c.namespace, c.source
+	h := hash(key)
+	return filepath.Join(c.dir, h[:2], h+".json")
+}
+
+// hash computes the content address of a key
+func hash(key Key) string {
+	markets := append([]int(nil), key.Markets...)
+	sort.Ints(markets)
+	key.Markets = markets
+
+	// Marshalling a struct always orders fields the same way, so equal keys
+	// have equal hashes
+	raw, _ := json.Marshal(key)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyFor builds the key for data fetched with opts
+func keyFor(kind string, domainID int, keyword string, opts data.QueryOptions) Key {
+	const day = "2006-01-02"
+
+	var date string
+	switch {
+	case !opts.Date.IsZero():
+		date = opts.Date.Format(day)
+	case !opts.From.IsZero() || !opts.To.IsZero():
+		date = "range:"
+		if !opts.From.IsZero() {
+			date += opts.From.Format(day)
+		}
+		date += "/"
+		if !opts.To.IsZero() {
+			date += opts.To.Format(day)
+		}
+	default:
+		date = "latest"
+	}
+
+	return Key{
+		Kind:     kind,
+		DomainID: domainID,
+		Keyword:  keyword,
+		Markets:  opts.MarketIDs,
+		Date:     date,
+		MaxRank:  opts.MaxRank,
+		Limit:    opts.Limit,
+	}
+}
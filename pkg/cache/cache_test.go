@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+)
+
+func tempCache(t *testing.T, options ...Option) (*Cache, func()) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+
+	c, err := New(dir, options...)
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func TestCacheTTL(t *testing.T) {
+	c, cleanup := tempCache(t, WithTTL(time.Hour))
+	defer cleanup()
+
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	key := Key{Kind: "keywords", DomainID: 6290, Markets: []int{2, 1}, Date: "latest"}
+	if err := c.Put(key, []string{"a", "b"}); err != nil {
+		t.Fatalf("could not put: %v", err)
+	}
+
+	var got []string
+	reordered := key
+	reordered.Markets = []int{1, 2}
+	if err := c.Get(reordered, &got); err != nil || !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected a hit with reordered markets, got %v, %v", got, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := c.Get(key, &got); err != ErrMiss {
+		t.Errorf("expected an expired entry to miss, got %v", err)
+	}
+
+	c.mode = Offline
+	if err := c.Get(key, &got); err != nil {
+		t.Errorf("expected offline mode to ignore expiry, got %v", err)
+	}
+
+	c.mode = Refresh
+	if err := c.Get(key, &got); err != ErrMiss {
+		t.Errorf("expected refresh mode to miss, got %v", err)
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	c, cleanup := tempCache(t)
+	defer cleanup()
+
+	key := Key{Kind: "keywords", DomainID: 6290, Date: "latest"}
+	if err := c.Put(key, []string{"a"}); err != nil {
+		t.Fatalf("could not put: %v", err)
+	}
+
+	other, err := New(c.dir, WithNamespace("warehouse"))
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+	var got []string
+	if err := other.Get(key, &got); err != ErrMiss {
+		t.Errorf("expected another namespace to miss, got %v", err)
+	}
+
+	elsewhere, err := New(c.dir, WithSource("kcf@staging:5432/product"))
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+	if err := elsewhere.Get(key, &got); err != ErrMiss {
+		t.Errorf("expected another database to miss, got %v", err)
+	}
+}
+
+// fakeSource answers SERPs with a single row per keyword and counts the
+// keywords it was asked for
+type fakeSource struct {
+	fetched *[]string
+}
+
+func (f fakeSource) FetchKeywords(ctx context.Context, domainID int, opts data.QueryOptions) ([]string, error) {
+	return []string{"a", "b"}, nil
+}
+
+func (f fakeSource) FetchSERPs(ctx context.Context, domainID int, keywords []string, opts data.QueryOptions, eachBatch func([]string, []data.SERPRow) error) (data.FetchReport, error) {
+	*f.fetched = append(*f.fetched, keywords...)
+	var rows []data.SERPRow
+	for _, kw := range keywords {
+		rows = append(rows, data.SERPRow{Keyword: kw, Prominence: 1, Domain: kw + ".com"})
+	}
+	return data.NewFetchReport(), eachBatch(keywords, rows)
+}
+
+func TestSource(t *testing.T) {
+	c, cleanup := tempCache(t)
+	defer cleanup()
+
+	var fetched []string
+	s := NewSource(c, fakeSource{fetched: &fetched})
+	opts := data.DefaultQueryOptions()
+	collect := func(keywords []string) map[string]string {
+		domains := make(map[string]string)
+		_, err := s.FetchSERPs(context.Background(), 6290, keywords, opts, func(_ []string, rows []data.SERPRow) error {
+			for _, r := range rows {
+				domains[r.Keyword] = r.Domain
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return domains
+	}
+
+	collect([]string{"a", "b"})
+	fetched = nil
+	domains := collect([]string{"a", "b", "c"})
+	if !reflect.DeepEqual(fetched, []string{"c"}) {
+		t.Errorf("expected only 'c' to be fetched, got %v", fetched)
+	}
+	if domains["a"] != "a.com" || domains["c"] != "c.com" {
+		t.Errorf("unexpected SERPs %v", domains)
+	}
+
+	c.mode = Offline
+	offline := NewSource(c, nil)
+	report, err := offline.FetchSERPs(context.Background(), 6290, []string{"a", "d"}, opts, func([]string, []data.SERPRow) error {
+		return nil
+	})
+	if err == nil {
+		t.Errorf("expected an error for an uncached keyword offline")
+	}
+	if failed := report.FailedKeywords(); !reflect.DeepEqual(failed, []string{"d"}) {
+		t.Errorf("expected 'd' to fail offline, got %v", failed)
+	}
+}
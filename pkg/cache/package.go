@@ -0,0 +1,4 @@
+// Package cache keeps fetched keywords and SERPs on disk so repeated runs
+// against the same domain, for example to experiment with clustering
+// parameters, don't need to query the product database again.
+package cache
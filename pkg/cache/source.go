@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+)
+
+// Source is a data.Source that answers from the cache where it can and fetches
+// everything else from another source, caching the results
+type Source struct {
+	cache *Cache
+	inner data.Source
+}
+
+var _ data.Source = Source{}
+
+// NewSource wraps inner with the cache. inner may be nil if the cache is in
+// Offline mode
+func NewSource(c *Cache, inner data.Source) Source {
+	return Source{cache: c, inner: inner}
+}
+
+// FetchKeywords loads the keywords for a domain from the cache, or from the
+// wrapped source if they are not cached
+func (s Source) FetchKeywords(ctx context.Context, domainID int, opts data.QueryOptions) ([]string, error) {
+	key := keyFor("keywords", domainID, "", opts)
+
+	var keywords []string
+	err := s.cache.Get(key, &keywords)
+	if err == nil {
+		return keywords, nil
+	}
+	if err != ErrMiss {
+		return nil, err
+	}
+	if s.cache.mode == Offline || s.inner == nil {
+		return nil, fmt.Errorf("keywords for domain %d are not cached", domainID)
+	}
+
+	keywords, err = s.inner.FetchKeywords(ctx, domainID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return keywords, s.cache.Put(key, keywords)
+}
+
+// FetchSERPs loads SERPs for keywords from the cache, fetching the ones that
+// are not cached from the wrapped source and caching them. Cached SERPs are
+// delivered in a single batch before any are fetched. In Offline mode,
+// keywords that are not cached are reported as failed
+func (s Source) FetchSERPs(ctx context.Context, domainID int, keywords []string, opts data.QueryOptions, eachBatch func(batch []string, rows []data.SERPRow) error) (data.FetchReport, error) {
+	report := data.NewFetchReport()
+
+	var hits, misses []string
+	var rows []data.SERPRow
+	for _, kw := range keywords {
+		var cached []data.SERPRow
+		err := s.cache.Get(keyFor("serp", domainID, kw, opts), &cached)
+		if err == ErrMiss {
+			misses = append(misses, kw)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		hits = append(hits, kw)
+		rows = append(rows, cached...)
+	}
+
+	if len(hits) > 0 {
+		err := eachBatch(hits, rows)
+		if err != nil {
+			return report, err
+		}
+	}
+	if len(misses) == 0 {
+		return report, nil
+	}
+
+	if s.cache.mode == Offline || s.inner == nil {
+		for _, kw := range misses {
+			report.Failed[kw] = fmt.Errorf("not cached")
+		}
+		return report, fmt.Errorf("%d of %d keywords are not cached", len(misses), len(keywords))
+	}
+
+	inner, err := s.inner.FetchSERPs(ctx, domainID, misses, opts, func(batch []string, rows []data.SERPRow) error {
+		byKeyword := make(map[string][]data.SERPRow)
+		for _, r := range rows {
+			byKeyword[r.Keyword] = append(byKeyword[r.Keyword], r)
+		}
+		for _, kw := range batch {
+			// Keywords without rankings are cached too, as an empty SERP
+			serp := byKeyword[kw]
+			if serp == nil {
+				serp = []data.SERPRow{}
+			}
+			if err := s.cache.Put(keyFor("serp", domainID, kw, opts), serp); err != nil {
+				return err
+			}
+		}
+
+		return eachBatch(batch, rows)
+	})
+	for kw, n := range inner.Attempts {
+		report.Attempts[kw] = n
+	}
+	for kw, e := range inner.Failed {
+		report.Failed[kw] = e
+	}
+
+	return report, err
+}
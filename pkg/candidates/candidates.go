@@ -0,0 +1,90 @@
+package candidates
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// Pair is a pair of keywords, with A sorting before B
+type Pair struct {
+	A string
+	B string
+}
+
+// NewPair creates a pair of two keywords in either order
+func NewPair(a, b string) Pair {
+	if b < a {
+		a, b = b, a
+	}
+	return Pair{A: a, B: b}
+}
+
+// Generator chooses the keyword pairs to compute similarity for. Pairs left
+// out are taken to have no similarity at all
+type Generator interface {
+	// Candidates lists the pairs worth comparing, without duplicates, in
+	// order of A then B
+	Candidates(kd rankings.KeywordData) []Pair
+	// String describes the generator and its settings, to record alongside
+	// results computed with it
+	String() string
+}
+
+// New creates the generator named kind with its default settings: "index" for
+// an Index, "minhash" for MinHash, or "all" for none at all, so every pair is
+// compared
+func New(kind string) (Generator, error) {
+	switch kind {
+	case "index":
+		return NewIndex()
+	case "minhash":
+		return NewMinHash()
+	case "all", "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown candidate generator '%s'", kind)
+	}
+}
+
+// NewFromFlags creates the generator named kind as New does, configuring an
+// index with minShared and maxShare. Those only apply to an index, so other
+// generators refuse any values but the defaults, 1 and 0
+func NewFromFlags(kind string, minShared int, maxShare float64) (Generator, error) {
+	if kind == "index" {
+		return NewIndex(WithMinShared(minShared), WithMaxShare(maxShare))
+	}
+	if minShared != 1 || maxShare != 0 {
+		return nil, fmt.Errorf("minimum shared domains and maximum share only apply to the index generator")
+	}
+	return New(kind)
+}
+
+// sortPairs orders pairs by A then B
+func sortPairs(pairs []Pair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+}
+
+// topDomains returns the domains of the SERP's depth most prominent members,
+// or all of them if depth is 0
+func topDomains(serp rankings.SERP, depth int) []string {
+	members := append([]rankings.SERPMember(nil), serp.Members...)
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].Prominence < members[j].Prominence
+	})
+	if depth > 0 && len(members) > depth {
+		members = members[:depth]
+	}
+
+	domains := make([]string, len(members))
+	for i, m := range members {
+		domains[i] = m.Domain
+	}
+	return domains
+}
@@ -0,0 +1,29 @@
+package candidates
+
+import "testing"
+
+func TestNewFromFlags(t *testing.T) {
+	g, err := NewFromFlags("index", 2, 0.5)
+	if err != nil {
+		t.Fatalf("could not create index: %v", err)
+	}
+	if ix, ok := g.(*Index); !ok || ix.minShared != 2 || ix.maxShare != 0.5 {
+		t.Errorf("expected an index sharing 2 domains capped at 0.5, got %#v", g)
+	}
+
+	for _, kind := range []string{"all", "minhash"} {
+		if _, err := NewFromFlags(kind, 1, 0); err != nil {
+			t.Errorf("expected %s with default index settings to be accepted, got %v", kind, err)
+		}
+		if _, err := NewFromFlags(kind, 2, 0); err == nil {
+			t.Errorf("expected %s with a minimum shared domains to be refused", kind)
+		}
+		if _, err := NewFromFlags(kind, 1, 0.5); err == nil {
+			t.Errorf("expected %s with a maximum share to be refused", kind)
+		}
+	}
+
+	if _, err := NewFromFlags("bogus", 1, 0); err == nil {
+		t.Errorf("expected an unknown generator to be refused")
+	}
+}
@@ -0,0 +1,126 @@
+package candidates
+
+import (
+	"fmt"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// Index finds the pairs of keywords whose SERPs share domains, using an
+// inverted index from each domain to the keywords ranking it. SERPs sharing no
+// domain always have an RBO of 0, so with the default settings the candidates
+// are exactly the pairs with any similarity, found in time proportional to the
+// overlaps rather than to the number of pairs
+type Index struct {
+	minShared int
+	maxShare  float64
+}
+
+// IndexOption configures an Index generator
+type IndexOption func(ix *Index)
+
+// WithMinShared configures how many domains two SERPs must share to be
+// compared. The default is 1
+func WithMinShared(n int) IndexOption {
+	return func(ix *Index) {
+		ix.minShared = n
+	}
+}
+
+// WithMaxShare configures the document frequency cap: domains ranking for more
+// than this share of keywords, such as wikipedia.org, are ignored, since they
+// link nearly everything. Set it to 0, the default, to use every domain
+func WithMaxShare(share float64) IndexOption {
+	return func(ix *Index) {
+		ix.maxShare = share
+	}
+}
+
+// NewIndex creates an Index generator configured by options
+func NewIndex(options ...IndexOption) (*Index, error) {
+	ix := &Index{minShared: 1}
+	for _, o := range options {
+		o(ix)
+	}
+
+	if ix.minShared < 1 {
+		return nil, fmt.Errorf("minimum shared domains must be positive")
+	}
+	if ix.maxShare < 0 || ix.maxShare > 1 {
+		return nil, fmt.Errorf("maximum share must be between 0 and 1")
+	}
+	return ix, nil
+}
+
+// Postings builds the inverted index: for each domain, the positions in
+// keywords of the keywords whose SERPs rank it, in ascending order. Domains
+// above the document frequency cap are left out
+func (ix *Index) Postings(kd rankings.KeywordData, keywords []string) map[string][]int {
+	postings := make(map[string][]int)
+	for i, kw := range keywords {
+		seen := make(map[string]bool)
+		for _, m := range kd[kw].Members {
+			if seen[m.Domain] {
+				continue
+			}
+			seen[m.Domain] = true
+			postings[m.Domain] = append(postings[m.Domain], i)
+		}
+	}
+
+	if ix.maxShare > 0 {
+		limit := ix.maxShare * float64(len(keywords))
+		for domain, list := range postings {
+			if float64(len(list)) > limit {
+				delete(postings, domain)
+			}
+		}
+	}
+	return postings
+}
+
+// Candidates lists the pairs of keywords sharing at least the minimum number
+// of indexed domains
+func (ix *Index) Candidates(kd rankings.KeywordData) []Pair {
+	keywords := kd.Keywords()
+	postings := ix.Postings(kd, keywords)
+
+	// For each keyword, count the domains shared with every later keyword.
+	// Only keywords it actually shares a domain with are touched
+	var pairs []Pair
+	shared := make([]int, len(keywords))
+	for i, kw := range keywords {
+		var touched []int
+		seen := make(map[string]bool)
+		for _, m := range kd[kw].Members {
+			list, ok := postings[m.Domain]
+			if !ok || seen[m.Domain] {
+				continue
+			}
+			seen[m.Domain] = true
+			for _, j := range list {
+				if j <= i {
+					continue
+				}
+				if shared[j] == 0 {
+					touched = append(touched, j)
+				}
+				shared[j]++
+			}
+		}
+
+		for _, j := range touched {
+			if shared[j] >= ix.minShared {
+				pairs = append(pairs, Pair{A: kw, B: keywords[j]})
+			}
+			shared[j] = 0
+		}
+	}
+
+	sortPairs(pairs)
+	return pairs
+}
+
+func (ix *Index) String() string {
+	return fmt.Sprintf("index(min-shared=%d, max-share=%g)", ix.minShared, ix.maxShare)
+}
@@ -0,0 +1,114 @@
+package candidates
+
+import (
+	"testing"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rbo"
+)
+
+func TestIndexRecall(t *testing.T) {
+	kd, err := rankings.ProcessDirectory("../rankings/test-data/6290")
+	if err != nil {
+		t.Fatalf("could not load fixtures: %v", err)
+	}
+
+	ix, err := NewIndex()
+	if err != nil {
+		t.Fatalf("could not create generator: %v", err)
+	}
+	pairs := ix.Candidates(kd)
+
+	// With the defaults the candidates are exactly the pairs with any
+	// similarity at all
+	found := make(map[Pair]bool)
+	for _, p := range pairs {
+		found[p] = true
+	}
+	keywords := kd.Keywords()
+	for i, a := range keywords {
+		for _, b := range keywords[i+1:] {
+			_, _, ext, err := rbo.RBO(kd[a], kd[b], 0.9)
+			if err != nil {
+				t.Fatalf("could not compute RBO: %v", err)
+			}
+			if (ext > 0) != found[NewPair(a, b)] {
+				t.Errorf("expected %s/%s as a candidate only if RBO > 0, got RBO %v", a, b, ext)
+			}
+		}
+	}
+
+	r, share := recall(t, kd, pairs, 0.2)
+	t.Logf("%s: recall %.2f comparing %.2f of pairs", ix, r, share)
+
+	strict, err := NewIndex(WithMinShared(3), WithMaxShare(0.5))
+	if err != nil {
+		t.Fatalf("could not create generator: %v", err)
+	}
+	fewer := strict.Candidates(kd)
+	r, share = recall(t, kd, fewer, 0.2)
+	t.Logf("%s: recall %.2f comparing %.2f of pairs", strict, r, share)
+	if len(fewer) >= len(pairs) {
+		t.Errorf("expected fewer candidates with stricter settings, got %d of %d", len(fewer), len(pairs))
+	}
+	for _, p := range fewer {
+		if !found[p] {
+			t.Errorf("expected stricter candidates to be a subset, got %v", p)
+		}
+	}
+}
+
+func TestIndexPostings(t *testing.T) {
+	serp := func(keyword string, domains ...string) rankings.SERP {
+		s := rankings.SERP{Keyword: keyword}
+		for i, d := range domains {
+			s.Members = append(s.Members, rankings.SERPMember{Keyword: keyword, Prominence: i + 1, Domain: d})
+		}
+		return s
+	}
+	kd := rankings.KeywordData{
+		"a": serp("a", "wiki.org", "x.com", "y.com", "x.com"),
+		"b": serp("b", "wiki.org", "x.com", "y.com"),
+		"c": serp("c", "wiki.org", "y.com"),
+		"d": serp("d", "wiki.org", "z.com"),
+	}
+
+	tests := []struct {
+		options []IndexOption
+		pairs   []Pair
+	}{
+		{nil, []Pair{{"a", "b"}, {"a", "c"}, {"a", "d"}, {"b", "c"}, {"b", "d"}, {"c", "d"}}},
+		// wiki.org ranks for every keyword, so the cap leaves it out
+		{[]IndexOption{WithMaxShare(0.75)}, []Pair{{"a", "b"}, {"a", "c"}, {"b", "c"}}},
+		// a repeats x.com, which still only counts once
+		{[]IndexOption{WithMinShared(2), WithMaxShare(0.75)}, []Pair{{"a", "b"}}},
+		{[]IndexOption{WithMinShared(3)}, []Pair{{"a", "b"}}},
+	}
+	for _, tt := range tests {
+		ix, err := NewIndex(tt.options...)
+		if err != nil {
+			t.Fatalf("could not create generator: %v", err)
+		}
+		pairs := ix.Candidates(kd)
+		if len(pairs) != len(tt.pairs) {
+			t.Errorf("%s: expected %v, got %v", ix, tt.pairs, pairs)
+			continue
+		}
+		for i := range pairs {
+			if pairs[i] != tt.pairs[i] {
+				t.Errorf("%s: expected %v, got %v", ix, tt.pairs, pairs)
+				break
+			}
+		}
+	}
+
+	for _, opts := range [][]IndexOption{
+		{WithMinShared(0)},
+		{WithMaxShare(-0.1)},
+		{WithMaxShare(1.5)},
+	} {
+		if _, err := NewIndex(opts...); err == nil {
+			t.Errorf("expected invalid options to be rejected")
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package candidates
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// MinHash finds pairs of keywords likely to be similar without comparing every
+// pair. Each SERP's top domains are summarized by a MinHash signature, whose
+// entries agree between two SERPs with probability equal to the Jaccard
+// similarity of their domains. Signatures are split into bands, and keywords
+// sharing every entry of at least one band become candidates. A pair with
+// Jaccard similarity s is found with probability 1 - (1 - s^rows)^bands.
+// Buckets holding too many keywords are skipped, as their pairs would cost as
+// much to compare as every pair
+type MinHash struct {
+	depth     int
+	bands     int
+	rows      int
+	maxBucket int
+	seed      int64
+	seeds     []uint64
+}
+
+// MinHashOption configures a MinHash generator
+type MinHashOption func(m *MinHash)
+
+// WithDepth configures how many of each SERP's most prominent domains are
+// hashed. Set it to 0 to hash every domain
+func WithDepth(depth int) MinHashOption {
+	return func(m *MinHash) {
+		m.depth = depth
+	}
+}
+
+// WithBands configures the number of bands and rows per band. More bands or
+// fewer rows find more pairs, at the cost of more candidates to compare
+func WithBands(bands, rows int) MinHashOption {
+	return func(m *MinHash) {
+		m.bands = bands
+		m.rows = rows
+	}
+}
+
+// WithMaxBucket configures the most keywords a bucket may hold for its pairs to
+// become candidates. A bucket of n keywords holds n(n-1)/2 pairs, and the
+// largest buckets are those of domains ranking for a large share of keywords,
+// such as wikipedia.org, which link nearly everything. Pairs that are truly
+// similar still share other buckets. Set it to 0 to keep every bucket
+func WithMaxBucket(n int) MinHashOption {
+	return func(m *MinHash) {
+		m.maxBucket = n
+	}
+}
+
+// WithSeed configures the seed the hash functions are drawn from. The same
+// seed always gives the same candidates
+func WithSeed(seed int64) MinHashOption {
+	return func(m *MinHash) {
+		m.seed = seed
+	}
+}
+
+// NewMinHash creates a MinHash generator configured by options. By default it
+// hashes the top 10 domains with 128 bands of 2 rows, skipping buckets of more
+// than 500 keywords. With 2 rows a band only matches when SERPs share two of
+// their top domains, so a single ubiquitous domain no longer puts most keywords
+// in the same bucket. SERPs sharing a third of their top domains are almost
+// always compared, while those sharing one or two may be missed
+func NewMinHash(options ...MinHashOption) (*MinHash, error) {
+	m := &MinHash{
+		depth:     10,
+		bands:     128,
+		rows:      2,
+		maxBucket: 500,
+		seed:      1,
+	}
+
+	for _, o := range options {
+		o(m)
+	}
+	if m.depth < 0 {
+		return nil, fmt.Errorf("depth must not be negative")
+	}
+	if m.bands < 1 || m.rows < 1 {
+		return nil, fmt.Errorf("bands and rows must be positive")
+	}
+	if m.maxBucket < 0 {
+		return nil, fmt.Errorf("maximum bucket size must not be negative")
+	}
+
+	// Each entry of the signature has its own hash function, drawn from the
+	// seed
+	r := rand.New(rand.NewSource(m.seed))
+	m.seeds = make([]uint64, m.bands*m.rows)
+	for i := range m.seeds {
+		m.seeds[i] = r.Uint64()
+	}
+
+	return m, nil
+}
+
+// Threshold is the Jaccard similarity at which a pair has an even chance of
+// becoming a candidate
+func (m *MinHash) Threshold() float64 {
+	return math.Pow(1/float64(m.bands), 1/float64(m.rows))
+}
+
+// Signature computes the MinHash signature of the SERP's top domains. A SERP
+// without members has no signature
+func (m *MinHash) Signature(serp rankings.SERP) []uint64 {
+	domains := topDomains(serp, m.depth)
+	if len(domains) == 0 {
+		return nil
+	}
+
+	hashes := make([]uint64, len(domains))
+	for i, d := range domains {
+		h := fnv.New64a()
+		h.Write([]byte(d))
+		hashes[i] = h.Sum64()
+	}
+
+	sig := make([]uint64, len(m.seeds))
+	for i, seed := range m.seeds {
+		sig[i] = math.MaxUint64
+		for _, h := range hashes {
+			if v := mix(h ^ seed); v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// mix scrambles a 64 bit value, as the finalizer of SplitMix64 does, so each
+// seed gives an independent hash function
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Candidates lists the pairs of keywords sharing a bucket in at least one band,
+// leaving out buckets above the size limit
+func (m *MinHash) Candidates(kd rankings.KeywordData) []Pair {
+	keywords := kd.Keywords()
+	signatures := make([][]uint64, len(keywords))
+	for i, kw := range keywords {
+		signatures[i] = m.Signature(kd[kw])
+	}
+
+	// Similar pairs share many buckets, so each is recorded once, keyed by
+	// both positions packed into one value
+	seen := make(map[uint64]struct{})
+	var pairs []Pair
+	buf := make([]byte, 8)
+	for band := 0; band < m.bands; band++ {
+		buckets := make(map[uint64][]int)
+		for i, sig := range signatures {
+			if sig == nil {
+				continue
+			}
+			h := fnv.New64a()
+			for _, v := range sig[band*m.rows : (band+1)*m.rows] {
+				binary.LittleEndian.PutUint64(buf, v)
+				h.Write(buf)
+			}
+			key := h.Sum64()
+			buckets[key] = append(buckets[key], i)
+		}
+
+		for _, bucket := range buckets {
+			if m.maxBucket > 0 && len(bucket) > m.maxBucket {
+				continue
+			}
+			for x, i := range bucket {
+				for _, j := range bucket[x+1:] {
+					key := uint64(i)<<32 | uint64(j)
+					if _, ok := seen[key]; ok {
+						continue
+					}
+					seen[key] = struct{}{}
+					pairs = append(pairs, Pair{A: keywords[i], B: keywords[j]})
+				}
+			}
+		}
+	}
+
+	sortPairs(pairs)
+	return pairs
+}
+
+func (m *MinHash) String() string {
+	return fmt.Sprintf("minhash(depth=%d, bands=%d, rows=%d, max-bucket=%d, seed=%d)", m.depth, m.bands, m.rows, m.maxBucket, m.seed)
+}
@@ -0,0 +1,209 @@
+package candidates
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rbo"
+)
+
+// recall measures the share of pairs with an RBO of at least minRBO that are
+// among the candidates, along with the share of all pairs that are candidates
+func recall(t *testing.T, kd rankings.KeywordData, pairs []Pair, minRBO float64) (float64, float64) {
+	found := make(map[Pair]bool)
+	for _, p := range pairs {
+		found[p] = true
+	}
+
+	keywords := kd.Keywords()
+	var relevant, hits int
+	for i, a := range keywords {
+		for _, b := range keywords[i+1:] {
+			_, _, ext, err := rbo.RBO(kd[a], kd[b], 0.9)
+			if err != nil {
+				t.Fatalf("could not compute RBO: %v", err)
+			}
+			if ext < minRBO {
+				continue
+			}
+			relevant++
+			if found[NewPair(a, b)] {
+				hits++
+			}
+		}
+	}
+
+	all := len(keywords) * (len(keywords) - 1) / 2
+	if relevant == 0 {
+		t.Fatalf("expected fixtures with related keywords")
+	}
+	return float64(hits) / float64(relevant), float64(len(pairs)) / float64(all)
+}
+
+func TestMinHashRecall(t *testing.T) {
+	kd, err := rankings.ProcessDirectory("../rankings/test-data/6290")
+	if err != nil {
+		t.Fatalf("could not load fixtures: %v", err)
+	}
+
+	m, err := NewMinHash()
+	if err != nil {
+		t.Fatalf("could not create generator: %v", err)
+	}
+	pairs := m.Candidates(kd)
+
+	// Pairs sharing only one or two top domains may be missed, and on this
+	// small fixture some of those still have an RBO of 0.2
+	r, share := recall(t, kd, pairs, 0.2)
+	t.Logf("%s: recall %.2f of pairs with RBO >= 0.2, comparing %.2f of pairs", m, r, share)
+	r, _ = recall(t, kd, pairs, 0.3)
+	if r < 0.95 {
+		t.Errorf("expected a recall of at least 0.95 for pairs with RBO >= 0.3, got %.2f", r)
+	}
+	if share > 0.25 {
+		t.Errorf("expected at most a quarter of pairs as candidates, got %.2f", share)
+	}
+
+	for i, p := range pairs {
+		if p.A >= p.B {
+			t.Errorf("expected pair %v in order", p)
+		}
+		if i > 0 && !(pairs[i-1].A < p.A || (pairs[i-1].A == p.A && pairs[i-1].B < p.B)) {
+			t.Errorf("expected pairs sorted without duplicates, got %v after %v", p, pairs[i-1])
+		}
+	}
+}
+
+// topicFixture builds SERPs for n keywords spread over topics. Each SERP ranks
+// 8 of its topic's 10 domains at the top, then domains of its own, and with
+// probability ubiquity also wikipedia.org among its top 5
+func topicFixture(n, topics int, ubiquity float64) rankings.KeywordData {
+	r := rand.New(rand.NewSource(1))
+	kd := rankings.New()
+	for i := 0; i < n; i++ {
+		topic := i % topics
+		kw := fmt.Sprintf("topic%d keyword %d", topic, i)
+		var domains []string
+		for _, j := range r.Perm(10)[:8] {
+			domains = append(domains, fmt.Sprintf("topic%d-%d.com", topic, j))
+		}
+		if r.Float64() < ubiquity {
+			pos := r.Intn(5)
+			domains = append(domains[:pos], append([]string{"wikipedia.org"}, domains[pos:]...)...)
+		}
+		for j := 0; j < 10; j++ {
+			domains = append(domains, fmt.Sprintf("keyword%d-%d.com", i, j))
+		}
+
+		serp := rankings.SERP{Keyword: kw}
+		for p, d := range domains {
+			serp.Members = append(serp.Members, rankings.SERPMember{Keyword: kw, Prominence: p + 1, Domain: d})
+		}
+		kd[kw] = serp
+	}
+	return kd
+}
+
+func TestMinHashTopics(t *testing.T) {
+	// Too many keywords to compute RBO for every pair, so recall is measured
+	// against the topics the SERPs were built from
+	n, topics := 10000, 500
+	kd := topicFixture(n, topics, 0.9)
+
+	m, err := NewMinHash()
+	if err != nil {
+		t.Fatalf("could not create generator: %v", err)
+	}
+	pairs := m.Candidates(kd)
+
+	var hits int
+	for _, p := range pairs {
+		if strings.Fields(p.A)[0] == strings.Fields(p.B)[0] {
+			hits++
+		}
+	}
+	perTopic := n / topics
+	r := float64(hits) / float64(topics*perTopic*(perTopic-1)/2)
+	share := float64(len(pairs)) / float64(n*(n-1)/2)
+	t.Logf("%s: recall %.2f comparing %.4f of pairs", m, r, share)
+	if r < 0.99 {
+		t.Errorf("expected a recall of at least 0.99 for pairs in the same topic, got %.2f", r)
+	}
+	if share > 0.01 {
+		t.Errorf("expected at most 1%% of pairs as candidates despite wikipedia.org, got %.4f", share)
+	}
+}
+
+func TestMinHashMaxBucket(t *testing.T) {
+	// Identical SERPs share every bucket
+	kd := rankings.New()
+	for i := 0; i < 600; i++ {
+		kw := fmt.Sprintf("keyword %d", i)
+		serp := rankings.SERP{Keyword: kw}
+		for _, d := range []string{"a.com", "b.com", "c.com"} {
+			serp.Members = append(serp.Members, rankings.SERPMember{Keyword: kw, Prominence: len(serp.Members) + 1, Domain: d})
+		}
+		kd[kw] = serp
+	}
+
+	m, err := NewMinHash()
+	if err != nil {
+		t.Fatalf("could not create generator: %v", err)
+	}
+	if pairs := m.Candidates(kd); len(pairs) != 0 {
+		t.Errorf("expected buckets above the limit to be skipped, got %d pairs", len(pairs))
+	}
+
+	m, err = NewMinHash(WithMaxBucket(0))
+	if err != nil {
+		t.Fatalf("could not create generator: %v", err)
+	}
+	if pairs := m.Candidates(kd); len(pairs) != 600*599/2 {
+		t.Errorf("expected every pair without a limit, got %d", len(pairs))
+	}
+}
+
+func TestMinHashSignature(t *testing.T) {
+	m, err := NewMinHash(WithDepth(2), WithBands(8, 2), WithSeed(7))
+	if err != nil {
+		t.Fatalf("could not create generator: %v", err)
+	}
+
+	serp := func(domains ...string) rankings.SERP {
+		s := rankings.SERP{Keyword: "kw"}
+		for i, d := range domains {
+			// Listed out of order to check the most prominent are used
+			s.Members = append(s.Members, rankings.SERPMember{Keyword: "kw", Prominence: len(domains) - i, Domain: d})
+		}
+		return s
+	}
+
+	a := m.Signature(serp("x.com", "a.com", "b.com"))
+	b := m.Signature(serp("y.com", "b.com", "a.com"))
+	if len(a) != 16 {
+		t.Fatalf("expected a signature of 16 entries, got %d", len(a))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("expected equal signatures for the same top domains")
+			break
+		}
+	}
+	if m.Signature(rankings.SERP{}) != nil {
+		t.Errorf("expected no signature for an empty SERP")
+	}
+
+	for _, opts := range [][]MinHashOption{
+		{WithDepth(-1)},
+		{WithBands(0, 1)},
+		{WithBands(4, 0)},
+		{WithMaxBucket(-1)},
+	} {
+		if _, err := NewMinHash(opts...); err == nil {
+			t.Errorf("expected invalid options to be rejected")
+		}
+	}
+}
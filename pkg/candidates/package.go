@@ -0,0 +1,3 @@
+// Package candidates chooses which pairs of keywords are worth comparing, so
+// large datasets need not compute RBO for every pair when most share nothing.
+package candidates
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+	"github.com/thedahv/keyword-cluster-finder/pkg/snapshot"
+)
+
+// LoadConfig reads the app config, or leaves all settings to environment
+// variables if there isn't one
+func LoadConfig(path string) (data.Config, error) {
+	if path == "" {
+		return data.Config{}, nil
+	}
+	fmt.Println("parsing config...")
+	return data.LoadConfig(path)
+}
+
+// RetryPolicy builds the database retry policy from command line flags
+func RetryPolicy(retries int, backoff time.Duration) data.RetryPolicy {
+	p := data.DefaultRetryPolicy()
+	p.MaxAttempts = retries + 1
+	p.InitialBackoff = backoff
+	return p
+}
+
+// QueryOptions builds the database query options from command line flags
+func QueryOptions(markets, date, from, to string, maxRank, limit int) (data.QueryOptions, error) {
+	opts := data.DefaultQueryOptions()
+	opts.MaxRank = maxRank
+	opts.Limit = limit
+
+	if markets != "" {
+		for _, m := range strings.Split(markets, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(m))
+			if err != nil {
+				return opts, fmt.Errorf("invalid market ID '%s'", m)
+			}
+			opts.MarketIDs = append(opts.MarketIDs, id)
+		}
+	}
+
+	var err error
+	for _, d := range []struct {
+		value string
+		dest  *time.Time
+	}{
+		{date, &opts.Date},
+		{from, &opts.From},
+		{to, &opts.To},
+	} {
+		if d.value == "" {
+			continue
+		}
+		*d.dest, err = snapshot.ParseDate(d.value)
+		if err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, opts.Validate()
+}
+
+// SplitList splits a comma-separated flag value, such as a list of glob
+// patterns or domains, dropping empty entries
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryOptions(t *testing.T) {
+	opts, err := QueryOptions("1, 2", "", "2024-01-01", "2024-01-31", 10, 15)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(opts.MarketIDs) != 2 || opts.MarketIDs[0] != 1 || opts.MarketIDs[1] != 2 {
+		t.Errorf("expected markets 1 and 2, got %v", opts.MarketIDs)
+	}
+	if !opts.From.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !opts.To.Equal(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected range %v to %v", opts.From, opts.To)
+	}
+	if opts.MaxRank != 10 || opts.Limit != 15 {
+		t.Errorf("expected max rank 10 and limit 15, got %d and %d", opts.MaxRank, opts.Limit)
+	}
+
+	for _, bad := range [][]string{
+		{"a", "", "", ""},
+		{"", "yesterday", "", ""},
+		{"", "2024-01-01", "2024-01-01", ""},
+		{"", "", "2024-02-01", "2024-01-01"},
+	} {
+		if _, err := QueryOptions(bad[0], bad[1], bad[2], bad[3], 20, 20); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy(3, time.Second)
+	if p.MaxAttempts != 4 || p.InitialBackoff != time.Second {
+		t.Errorf("expected 4 attempts starting at a second, got %+v", p)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := SplitList(" *.json, ,6290/*,")
+	if len(got) != 2 || got[0] != "*.json" || got[1] != "6290/*" {
+		t.Errorf("expected two patterns, got %q", got)
+	}
+	if got := SplitList(""); len(got) != 0 {
+		t.Errorf("expected no entries, got %q", got)
+	}
+}
@@ -0,0 +1,3 @@
+// Package cli holds the helpers the commands in bin share for turning command
+// line flags into configuration.
+package cli
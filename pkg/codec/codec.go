@@ -0,0 +1,304 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+)
+
+// maxLength bounds the length of strings and headers read, so a corrupt file
+// cannot make us allocate without limit
+const maxLength = 1 << 24
+
+// Writer encodes a binary file. Errors are sticky: once a write fails, later
+// writes do nothing and Close returns the error
+type Writer struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+// NewWriter starts a file of the given kind, identified by a four byte magic
+// number, writing the version and header, encoded as JSON, ahead of the body
+func NewWriter(w io.Writer, magic string, version int, header interface{}) *Writer {
+	crc := crc32.NewIEEE()
+	cw := &Writer{
+		w:   bufio.NewWriter(io.MultiWriter(w, crc)),
+		crc: crc,
+	}
+
+	if len(magic) != 4 {
+		cw.err = fmt.Errorf("magic number must be 4 bytes, got '%s'", magic)
+		return cw
+	}
+	raw, err := json.Marshal(header)
+	if err != nil {
+		cw.err = fmt.Errorf("could not encode header: %v", err)
+		return cw
+	}
+
+	cw.write([]byte(magic))
+	cw.Uvarint(uint64(version))
+	cw.Uvarint(uint64(len(raw)))
+	cw.write(raw)
+	return cw
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(p)
+}
+
+// Uvarint writes an unsigned integer
+func (w *Writer) Uvarint(v uint64) {
+	n := binary.PutUvarint(w.buf[:], v)
+	w.write(w.buf[:n])
+}
+
+// Varint writes a signed integer
+func (w *Writer) Varint(v int64) {
+	n := binary.PutVarint(w.buf[:], v)
+	w.write(w.buf[:n])
+}
+
+// Float64 writes a float exactly, as its IEEE 754 bits
+func (w *Writer) Float64(f float64) {
+	binary.LittleEndian.PutUint64(w.buf[:8], math.Float64bits(f))
+	w.write(w.buf[:8])
+}
+
+// String writes a length-prefixed string
+func (w *Writer) String(s string) {
+	w.Uvarint(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+// Close writes the checksum of everything written so far and flushes the
+// file. It does not close the underlying writer
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], w.crc.Sum32())
+	w.write(sum[:])
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// Reader decodes a binary file written by Writer. Errors are sticky: once a
+// read fails, later reads return zero values and Close returns the error
+type Reader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+	// Version is the format version the file was written with
+	Version int
+}
+
+// NewReader reads the magic number, version and header of a file, decoding
+// the header into header. It fails if the file is not of the given kind or was
+// written by a newer version than maxVersion
+func NewReader(r io.Reader, magic string, maxVersion int, header interface{}) (*Reader, error) {
+	cr := &Reader{
+		r:   bufio.NewReader(r),
+		crc: crc32.NewIEEE(),
+	}
+
+	got := make([]byte, len(magic))
+	cr.read(got)
+	if cr.err != nil {
+		return nil, fmt.Errorf("could not read file: %v", cr.err)
+	}
+	if string(got) != magic {
+		return nil, fmt.Errorf("not a %s file", magic)
+	}
+
+	cr.Version = int(cr.Uvarint())
+	if cr.err == nil && (cr.Version < 1 || cr.Version > maxVersion) {
+		return nil, fmt.Errorf("unsupported %s format version %d", magic, cr.Version)
+	}
+
+	raw := make([]byte, cr.length())
+	cr.read(raw)
+	if cr.err != nil {
+		return nil, fmt.Errorf("could not read header: %v", cr.err)
+	}
+	if err := json.Unmarshal(raw, header); err != nil {
+		return nil, fmt.Errorf("could not parse header: %v", err)
+	}
+
+	return cr, nil
+}
+
+func (r *Reader) read(p []byte) {
+	if r.err != nil {
+		return
+	}
+	_, err := io.ReadFull(r.r, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	r.err = err
+	r.crc.Write(p)
+}
+
+// Uvarint reads an unsigned integer
+func (r *Reader) Uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	for i := range buf {
+		r.read(buf[i : i+1])
+		if r.err != nil {
+			return 0
+		}
+		if buf[i] < 0x80 {
+			v, _ := binary.Uvarint(buf[:i+1])
+			return v
+		}
+	}
+	r.err = fmt.Errorf("varint overflows 64 bits")
+	return 0
+}
+
+// Varint reads a signed integer
+func (r *Reader) Varint() int64 {
+	u := r.Uvarint()
+	// Undo the zig-zag encoding used by binary.PutVarint
+	v := int64(u >> 1)
+	if u&1 != 0 {
+		v = ^v
+	}
+	return v
+}
+
+// Float64 reads a float written by Writer.Float64
+func (r *Reader) Float64() float64 {
+	var buf [8]byte
+	r.read(buf[:])
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+}
+
+// String reads a length-prefixed string
+func (r *Reader) String() string {
+	buf := make([]byte, r.length())
+	r.read(buf)
+	return string(buf)
+}
+
+// Index reads a reference to one of n entries, such as a string in a table
+func (r *Reader) Index(n int) int {
+	i := r.Uvarint()
+	if r.err == nil && i >= uint64(n) {
+		r.err = fmt.Errorf("index %d out of range of %d entries", i, n)
+		return 0
+	}
+	return int(i)
+}
+
+// length reads a length, rejecting ones too large to be genuine
+func (r *Reader) length() int {
+	n := r.Uvarint()
+	if r.err == nil && n > maxLength {
+		r.err = fmt.Errorf("length %d is too large", n)
+		return 0
+	}
+	return int(n)
+}
+
+// Err returns the first error reading the file, if any
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close checks the file's trailing checksum against what was read. Call it
+// once the whole body has been read
+func (r *Reader) Close() error {
+	if r.err != nil {
+		return fmt.Errorf("could not read file: %v", r.err)
+	}
+
+	expected := r.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(r.r, sum[:]); err != nil {
+		return fmt.Errorf("could not read checksum: %v", err)
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != expected {
+		return fmt.Errorf("checksum mismatch: file is corrupt")
+	}
+	return nil
+}
+
+// Strings interns strings so each is written once, in a table ahead of the
+// body, and referred to by index afterwards
+type Strings struct {
+	index map[string]int
+	table []string
+}
+
+// NewStrings builds a table of the distinct strings given, in sorted order so
+// the encoding is deterministic
+func NewStrings(strings []string) Strings {
+	s := Strings{index: make(map[string]int)}
+	for _, str := range strings {
+		if _, ok := s.index[str]; !ok {
+			s.index[str] = 0
+			s.table = append(s.table, str)
+		}
+	}
+	sort.Strings(s.table)
+	for i, str := range s.table {
+		s.index[str] = i
+	}
+	return s
+}
+
+// Table lists the strings in the order they are written
+func (s Strings) Table() []string {
+	return s.table
+}
+
+// Write writes the table
+func (s Strings) Write(w *Writer) {
+	w.Uvarint(uint64(len(s.table)))
+	for _, str := range s.table {
+		w.String(str)
+	}
+}
+
+// Ref writes a reference to a string in the table
+func (s Strings) Ref(w *Writer, str string) {
+	i, ok := s.index[str]
+	if !ok && w.err == nil {
+		w.err = fmt.Errorf("string '%s' is not in the table", str)
+	}
+	w.Uvarint(uint64(i))
+}
+
+// ReadStrings reads a table written by Strings.Write
+func ReadStrings(r *Reader) []string {
+	n := r.length()
+	var table []string
+	for i := 0; i < n && r.err == nil; i++ {
+		table = append(table, r.String())
+	}
+	return table
+}
@@ -0,0 +1,98 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type header struct {
+	Name string `json:"name"`
+}
+
+func encode(t *testing.T, version int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, "TEST", version, header{Name: "fixture"})
+	table := NewStrings([]string{"b.com", "a.com", "b.com"})
+	table.Write(w)
+	table.Ref(w, "b.com")
+	w.Uvarint(300)
+	w.Varint(-7)
+	w.Float64(0.125)
+	w.String("keyword")
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	var h header
+	r, err := NewReader(bytes.NewReader(encode(t, 1)), "TEST", 1, &h)
+	if err != nil {
+		t.Fatalf("could not read: %v", err)
+	}
+	if h.Name != "fixture" || r.Version != 1 {
+		t.Errorf("unexpected header %v, version %d", h, r.Version)
+	}
+
+	table := ReadStrings(r)
+	if strings.Join(table, ",") != "a.com,b.com" {
+		t.Errorf("expected a sorted table without duplicates, got %v", table)
+	}
+	if s := table[r.Index(len(table))]; s != "b.com" {
+		t.Errorf("expected a reference to b.com, got %s", s)
+	}
+	if v := r.Uvarint(); v != 300 {
+		t.Errorf("expected 300, got %d", v)
+	}
+	if v := r.Varint(); v != -7 {
+		t.Errorf("expected -7, got %d", v)
+	}
+	if f := r.Float64(); f != 0.125 {
+		t.Errorf("expected 0.125, got %v", f)
+	}
+	if s := r.String(); s != "keyword" {
+		t.Errorf("expected keyword, got %s", s)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("expected a valid checksum, got %v", err)
+	}
+}
+
+func TestReaderRejects(t *testing.T) {
+	valid := encode(t, 1)
+	corrupt := append([]byte(nil), valid...)
+	corrupt[len(corrupt)-6] ^= 0xff
+
+	tests := map[string]struct {
+		data    []byte
+		magic   string
+		version int
+	}{
+		"wrong magic":   {valid, "ELSE", 1},
+		"newer version": {encode(t, 2), "TEST", 1},
+		"truncated":     {valid[:len(valid)-3], "TEST", 1},
+		"corrupt body":  {corrupt, "TEST", 1},
+		"empty":         {nil, "TEST", 1},
+		"header only":   {valid[:10], "TEST", 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var h header
+			r, err := NewReader(bytes.NewReader(tt.data), tt.magic, tt.version, &h)
+			if err != nil {
+				return
+			}
+			table := ReadStrings(r)
+			r.Index(len(table))
+			r.Uvarint()
+			r.Varint()
+			r.Float64()
+			_ = r.String()
+			if err := r.Close(); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
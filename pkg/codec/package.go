@@ -0,0 +1,5 @@
+// Package codec implements the versioned binary container shared by the
+// compact keyword data and similarity files: a magic number, a format version,
+// a JSON header describing the contents, a varint-encoded body and a trailing
+// checksum.
+package codec
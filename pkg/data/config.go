@@ -0,0 +1,72 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Config is the app JSON config describing how to reach the database. See
+// bin/build-from-db/test-data/config.schema.json for an example. Any setting
+// left out falls back to the DSN and then to the PG* environment variables,
+// such as PGPASSWORD or PGPASSFILE
+type Config struct {
+	DB struct {
+		DSN             string `json:"dsn"`
+		User            string `json:"user"`
+		Pass            string `json:"pass"`
+		Host            string `json:"host"`
+		Port            int    `json:"port"`
+		Database        string `json:"database"`
+		SSLMode         string `json:"sslmode"`
+		SSLRootCert     string `json:"sslrootcert"`
+		ApplicationName string `json:"application_name"`
+	} `json:"db"`
+}
+
+// LoadConfig reads the app JSON config from path
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	f, err := os.Open(path)
+	if err != nil {
+		return c, fmt.Errorf("could not open file: %v", err)
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return c, fmt.Errorf("could not read file: %v", err)
+	}
+
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("could not parse config: %v", err)
+	}
+
+	return c, nil
+}
+
+// Options converts the config to options for New
+func (c Config) Options() []Option {
+	return []Option{
+		WithDSN(c.DB.DSN),
+		WithUserAndPass(c.DB.User, c.DB.Pass),
+		WithHost(c.DB.Host),
+		WithPort(c.DB.Port),
+		WithDatabase(c.DB.Database),
+		WithSSLMode(c.DB.SSLMode),
+		WithSSLRootCert(c.DB.SSLRootCert),
+		WithApplicationName(c.DB.ApplicationName),
+	}
+}
+
+// Identity names the database the config connects to, without connecting. See
+// Driver.Identity
+func (c Config) Identity() (string, error) {
+	var d Driver
+	for _, o := range c.Options() {
+		o(&d)
+	}
+	return d.Identity()
+}
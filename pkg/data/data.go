@@ -1,11 +1,18 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
-	// lib/pg lets us communicate with Postgres databases
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const query = `
@@ -15,8 +22,7 @@ const query = `
 		JOIN keywords USING (keyword_id)
 		WHERE domain_id = $1
 		AND date IS NOT NULL
-		AND name = $2
-    -- TODO add filters for markets
+		AND name = $2{{filters}}
 	), serp_competitors AS (
 		SELECT
 		keyword,
@@ -27,10 +33,10 @@ const query = `
 		array_agg(avg_rank ORDER BY keyword_id, market_id) AS ranks
 		FROM domain_params
 		JOIN multisample_rankings mr USING (domain_id, keyword_id, market_id, date)
-		WHERE avg_rank <= 20
+		WHERE avg_rank <= {{max_rank}}
 		GROUP BY keyword, competitor
 		ORDER BY wilson DESC NULLS LAST
-		LIMIT 20
+		LIMIT {{limit}}
 	)
 	SELECT
 		keyword,
@@ -40,14 +46,82 @@ const query = `
 	WINDOW w AS (ORDER BY a.wilson DESC NULLS LAST)
 `
 
-// Driver manages connection to the data store
+// batchQuery is the same as query, but fetches SERPs for many keywords at once.
+// Prominence is ranked within each keyword's competitors
+const batchQuery = `
+	WITH domain_params AS (
+		SELECT *, keywords.name AS keyword
+		FROM v_serp_params
+		JOIN keywords USING (keyword_id)
+		WHERE domain_id = $1
+		AND date IS NOT NULL
+		AND name = ANY($2){{filters}}
+	), serp_competitors AS (
+		SELECT
+		keyword,
+		domain AS competitor,
+		max(date) AS date,
+		round(avg(avg_rank), 1) AS avg_rank,
+		f_wilson(array_remove(array_agg(avg_rank), NULL)) AS wilson,
+		array_agg(avg_rank ORDER BY keyword_id, market_id) AS ranks
+		FROM domain_params
+		JOIN multisample_rankings mr USING (domain_id, keyword_id, market_id, date)
+		WHERE avg_rank <= {{max_rank}}
+		GROUP BY keyword, competitor
+	), ranked AS (
+		SELECT
+			keyword,
+			(row_number() OVER w)::INT AS prominence,
+			a.competitor
+		FROM serp_competitors a
+		WINDOW w AS (PARTITION BY a.keyword ORDER BY a.wilson DESC NULLS LAST)
+	)
+	SELECT keyword, prominence, competitor
+	FROM ranked
+	WHERE prominence <= {{limit}}
+	ORDER BY keyword, prominence
+`
+
+const keywordsQuery = `
+	SELECT DISTINCT name AS keyword
+	FROM v_serp_params
+	JOIN keywords USING (keyword_id)
+	WHERE domain_id = $1{{filters}}
+`
+
+// Driver manages connection to the data store. It holds a pool of connections
+// for its whole lifetime, so call Close when finished with it
 type Driver struct {
-	User        string
-	Password    string
-	Host        string
-	Database    string
+	// DSN is a connection string, either a postgres:// URL or space-separated
+	// key=value settings. The other connection fields override its settings,
+	// and anything left unset falls back to the PG* environment variables
+	DSN             string
+	User            string
+	Password        string
+	Host            string
+	Port            int
+	Database        string
+	SSLMode         string
+	SSLRootCert     string
+	ApplicationName string
+
 	db          *sql.DB
+	ownsDB      bool
 	maxInFlight int
+	batchSize   int
+	templates   Templates
+
+	poolSize         int
+	connMaxLifetime  time.Duration
+	statementTimeout time.Duration
+	retryPolicy      RetryPolicy
+}
+
+// SERPRow is a competitor ranking in the SERP for a keyword
+type SERPRow struct {
+	Keyword    string
+	Prominence int
+	Domain     string
 }
 
 // Option configures a Driver
@@ -68,6 +142,13 @@ func WithHost(host string) Option {
 	}
 }
 
+// WithPort configures the driver with the connection port
+func WithPort(port int) Option {
+	return func(d *Driver) {
+		d.Port = port
+	}
+}
+
 // WithDatabase configures the driver with the database host
 func WithDatabase(database string) Option {
 	return func(d *Driver) {
@@ -75,6 +156,38 @@ func WithDatabase(database string) Option {
 	}
 }
 
+// WithDSN configures the driver with a connection string, as a postgres:// URL
+// or key=value settings. Other connection options override its settings
+func WithDSN(dsn string) Option {
+	return func(d *Driver) {
+		d.DSN = dsn
+	}
+}
+
+// WithSSLMode configures how the connection uses TLS: disable, require,
+// verify-ca or verify-full
+func WithSSLMode(mode string) Option {
+	return func(d *Driver) {
+		d.SSLMode = mode
+	}
+}
+
+// WithSSLRootCert configures the path to the certificate authority used to
+// verify the server with sslmode verify-ca or verify-full
+func WithSSLRootCert(path string) Option {
+	return func(d *Driver) {
+		d.SSLRootCert = path
+	}
+}
+
+// WithApplicationName configures the name the connection reports to the
+// server, so our queries can be told apart in pg_stat_activity
+func WithApplicationName(name string) Option {
+	return func(d *Driver) {
+		d.ApplicationName = name
+	}
+}
+
 // WithMaxInFlight configures the maximum amount of queries to run at once when collecting data
 func WithMaxInFlight(max int) Option {
 	return func(d *Driver) {
@@ -82,84 +195,467 @@ func WithMaxInFlight(max int) Option {
 	}
 }
 
-// New sets up a database driver
+// WithBatchSize configures the maximum number of keywords to fetch SERPs for in
+// a single query
+func WithBatchSize(size int) Option {
+	return func(d *Driver) {
+		d.batchSize = size
+	}
+}
+
+// WithPoolSize configures the maximum number of open connections to the
+// database. Defaults to the maximum number of queries in flight
+func WithPoolSize(size int) Option {
+	return func(d *Driver) {
+		d.poolSize = size
+	}
+}
+
+// WithConnMaxLifetime configures how long a connection may be reused before
+// it is closed and replaced. Zero means connections are reused forever
+func WithConnMaxLifetime(lifetime time.Duration) Option {
+	return func(d *Driver) {
+		d.connMaxLifetime = lifetime
+	}
+}
+
+// WithStatementTimeout configures the maximum time a single query may run
+// before it is cancelled. Zero means queries may run until their context is
+// done
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.statementTimeout = timeout
+	}
+}
+
+// WithRetryPolicy configures how queries that fail with transient errors are
+// retried
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(d *Driver) {
+		d.retryPolicy = p
+	}
+}
+
+// WithTemplates configures the queries used to fetch keywords and SERPs, for
+// warehouses with a different schema than ours
+func WithTemplates(t Templates) Option {
+	return func(d *Driver) {
+		d.templates = t
+	}
+}
+
+// WithDB configures the driver to run queries on an already opened database
+// rather than connecting with its own configuration. The database is left open
+// when the driver is closed
+func WithDB(db *sql.DB) Option {
+	return func(d *Driver) {
+		d.db = db
+	}
+}
+
+// New sets up a database driver. Connections are opened as they are needed
 func New(options ...Option) (*Driver, error) {
-	d := &Driver{maxInFlight: 5}
+	d := &Driver{
+		maxInFlight: 5,
+		batchSize:   500,
+		retryPolicy: DefaultRetryPolicy(),
+		templates:   DefaultTemplates(),
+	}
 	for _, opt := range options {
 		opt(d)
 	}
 
+	if err := d.templates.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid query templates: %v", err)
+	}
+
+	if d.db == nil {
+		conn, err := d.connString()
+		if err != nil {
+			return nil, fmt.Errorf("invalid connection configuration: %v", err)
+		}
+
+		db, err := sql.Open("postgres", conn)
+		if err != nil {
+			return nil, fmt.Errorf("could not open database: %v", err)
+		}
+		d.db = db
+		d.ownsDB = true
+	}
+
+	poolSize := d.poolSize
+	if poolSize <= 0 {
+		poolSize = max(d.maxInFlight, 1)
+	}
+	d.db.SetMaxOpenConns(poolSize)
+	d.db.SetMaxIdleConns(poolSize)
+	d.db.SetConnMaxLifetime(d.connMaxLifetime)
+
 	return d, nil
 }
 
-// withConn opens a connection to the database for the given operation and
-// closes it when it is finished
-func (d Driver) withConn(operation func(*sql.DB) error) error {
-	db, err := sql.Open("postgres", d.connString())
+// Close releases the driver's connections
+func (d Driver) Close() error {
+	if !d.ownsDB {
+		return nil
+	}
+	return d.db.Close()
+}
+
+// connString creates the connection string from the driver configuration, as
+// key=value settings. lib/pq keeps the last value given for a setting, so the
+// discrete fields are appended after the DSN to override it
+func (d Driver) connString() (string, error) {
+	var settings []string
+
+	dsn := strings.TrimSpace(d.DSN)
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			return "", fmt.Errorf("could not parse DSN: %v", err)
+		}
+	}
+	if dsn != "" {
+		settings = append(settings, dsn)
+	}
+
+	switch d.SSLMode {
+	case "", "disable", "require", "verify-ca", "verify-full":
+	default:
+		return "", fmt.Errorf("unsupported sslmode '%s'", d.SSLMode)
+	}
+
+	// Hosts used to be given as host:port, so keep accepting that
+	host, port := d.Host, ""
+	if h, p, err := net.SplitHostPort(d.Host); err == nil {
+		host, port = h, p
+	}
+	if d.Port != 0 {
+		port = strconv.Itoa(d.Port)
+	}
+
+	for _, s := range []struct {
+		key   string
+		value string
+	}{
+		{"host", host},
+		{"port", port},
+		{"user", d.User},
+		{"password", d.Password},
+		{"dbname", d.Database},
+		{"sslmode", d.SSLMode},
+		{"sslrootcert", d.SSLRootCert},
+		{"application_name", d.ApplicationName},
+	} {
+		if s.value == "" {
+			continue
+		}
+		settings = append(settings, s.key+"="+quoteSetting(s.value))
+	}
+
+	return strings.Join(settings, " "), nil
+}
+
+// quoteSetting quotes a connection setting value so spaces, quotes and
+// backslashes survive parsing
+func quoteSetting(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
+
+// Identity names the database the driver connects to as user@host:port/dbname,
+// resolving the DSN, connection fields and PG* environment variables the way
+// the connection does, but leaving out credentials. Data fetched under
+// different identities came from different databases
+func (d Driver) Identity() (string, error) {
+	conn, err := d.connString()
+	if err != nil {
+		return "", err
+	}
+	settings, err := parseSettings(conn)
 	if err != nil {
-		return fmt.Errorf("could not open database: %v", err)
+		return "", err
 	}
-	defer db.Close()
 
-	return operation(db)
+	get := func(key, env, fallback string) string {
+		if v, ok := settings[key]; ok && v != "" {
+			return v
+		}
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+		return fallback
+	}
+	user := get("user", "PGUSER", "")
+	return fmt.Sprintf("%s@%s:%s/%s", user,
+		get("host", "PGHOST", "localhost"),
+		get("port", "PGPORT", "5432"),
+		get("dbname", "PGDATABASE", user),
+	), nil
 }
 
-// connString creates the connection string from the driver configuration
-func (d Driver) connString() string {
-	return fmt.Sprintf(
-		"postgres://%s:%s@%s/%s",
-		d.User,
-		d.Password,
-		d.Host,
-		d.Database,
-	)
+// parseSettings splits key=value connection settings, as written by
+// connString, into a map. Later settings override earlier ones
+func parseSettings(conn string) (map[string]string, error) {
+	settings := make(map[string]string)
+	r := []rune(conn)
+	for i := 0; i < len(r); {
+		if unicode.IsSpace(r[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(r) && r[i] != '=' && !unicode.IsSpace(r[i]) {
+			i++
+		}
+		key := string(r[start:i])
+		for i < len(r) && unicode.IsSpace(r[i]) {
+			i++
+		}
+		if i == len(r) || r[i] != '=' {
+			return nil, fmt.Errorf("missing value for setting '%s'", key)
+		}
+		i++
+		for i < len(r) && unicode.IsSpace(r[i]) {
+			i++
+		}
+
+		var value []rune
+		if i < len(r) && r[i] == '\'' {
+			i++
+			for ; i < len(r) && r[i] != '\''; i++ {
+				if r[i] == '\\' && i+1 < len(r) {
+					i++
+				}
+				value = append(value, r[i])
+			}
+			if i == len(r) {
+				return nil, fmt.Errorf("unterminated quote in setting '%s'", key)
+			}
+			i++
+		} else {
+			for ; i < len(r) && !unicode.IsSpace(r[i]); i++ {
+				if r[i] == '\\' && i+1 < len(r) {
+					i++
+				}
+				value = append(value, r[i])
+			}
+		}
+		settings[key] = string(value)
+	}
+	return settings, nil
 }
 
-// FetchKeywords loads the keywords for a given domain
-func (d Driver) FetchKeywords(domainID int) ([]string, error) {
+// query runs a query, cancelling it if ctx is done or the statement timeout
+// passes. Call the returned cancel function once finished with the rows
+func (d Driver) query(ctx context.Context, q string, args ...interface{}) (*sql.Rows, context.CancelFunc, error) {
+	cancel := func() {}
+	if d.statementTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, d.statementTimeout)
+	}
+
+	rows, err := d.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	return rows, cancel, nil
+}
+
+// FetchKeywords loads the keywords for a given domain, limited to the markets
+// and dates in opts
+func (d Driver) FetchKeywords(ctx context.Context, domainID int, opts QueryOptions) ([]string, error) {
 	var keywords []string
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid query options: %v", err)
+	}
 
-	err := d.withConn(func(db *sql.DB) error {
-		rows, err := db.Query(`
-			SELECT DISTINCT name AS keyword
-			FROM v_serp_params
-			JOIN keywords USING (keyword_id)
-			WHERE domain_id = $1
-		`, domainID)
+	q, args := opts.keywordsQuery(d.templates, domainID)
+	_, err := d.retryPolicy.retry(ctx, func() error {
+		keywords = nil
+		rows, cancel, err := d.query(ctx, q, args...)
 		if err != nil {
-			return fmt.Errorf("could not query the database: %v", err)
+			return fmt.Errorf("could not query the database: %w", err)
 		}
+		defer cancel()
+		defer rows.Close()
 
+		cols, err := newColumnScanner(rows, d.templates.Columns.Keyword)
+		if err != nil {
+			return err
+		}
 		for rows.Next() {
 			var kw string
-			err := rows.Scan(&kw)
+			err := cols.scan(rows, &kw)
 			if err != nil {
-				return fmt.Errorf("could not parse keyword result: %v", err)
+				return fmt.Errorf("could not parse keyword result: %w", err)
 			}
 			keywords = append(keywords, kw)
 		}
 
-		return nil
+		return rows.Err()
 	})
 
 	return keywords, err
 }
 
-// FetchSERP loads prominent SERP members for a given keyword
-func (d Driver) FetchSERP(domainID int, keyword string, eachRow func(*sql.Rows) error) error {
-	return d.withConn(func(db *sql.DB) error {
-		rows, err := db.Query(query, domainID, keyword)
+// FetchSERP loads prominent SERP members for a given keyword, using the
+// rankings selected by opts. Rows are passed to eachRow as the query template
+// returns them. Running the query is retried on transient errors, but once
+// rows are being read errors are returned as they are
+func (d Driver) FetchSERP(ctx context.Context, domainID int, keyword string, opts QueryOptions, eachRow func(*sql.Rows) error) error {
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("invalid query options: %v", err)
+	}
+
+	q, args := opts.serpQuery(d.templates, domainID, keyword)
+	var rows *sql.Rows
+	var cancel context.CancelFunc
+	_, err := d.retryPolicy.retry(ctx, func() error {
+		var err error
+		rows, cancel, err = d.query(ctx, q, args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not query database: %w", err)
+	}
+	defer cancel()
+	defer rows.Close()
+
+	for rows.Next() {
+		err := eachRow(rows)
 		if err != nil {
-			return fmt.Errorf("could not query database: %v", err)
+			return fmt.Errorf("error parsing row: %v", err)
 		}
+	}
 
-		for rows.Next() {
-			err := eachRow(rows)
-			if err != nil {
-				return fmt.Errorf("error parsing row: %v", err)
+	return rows.Err()
+}
+
+// FetchSERPs loads prominent SERP members for many keywords. Keywords are split
+// into batches that are queried concurrently, up to the driver's maximum
+// number of queries in flight. Batches failing with transient errors are
+// retried according to the driver's retry policy. eachBatch is called once per
+// successful batch with the keywords in it and their rows, ordered by keyword
+// and prominence; it is never called concurrently. Keywords without rankings
+// have no rows.
+//
+// The report accounts for the attempts made for each keyword and lists those
+// that permanently failed, in which case an error is also returned. Once ctx
+// is done, no more batches are started and the error reports the cancellation
+func (d Driver) FetchSERPs(ctx context.Context, domainID int, keywords []string, opts QueryOptions, eachBatch func(batch []string, rows []SERPRow) error) (FetchReport, error) {
+	report := NewFetchReport()
+	if err := opts.Validate(); err != nil {
+		return report, fmt.Errorf("invalid query options: %v", err)
+	}
+
+	batches := chunk(keywords, d.batchSize)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	// A buffered channel limits the number of expensive queries running at
+	// once. It blocks when full, so a batch can only start as another finishes
+	sem := make(chan struct{}, max(d.maxInFlight, 1))
+	for _, batch := range batches {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var rows []SERPRow
+			attempts, err := d.retryPolicy.retry(ctx, func() error {
+				var err error
+				rows, err = d.fetchBatch(ctx, domainID, batch, opts)
+				return err
+			})
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err == nil {
+				err = eachBatch(batch, rows)
+			}
+			for _, kw := range batch {
+				report.Attempts[kw] += attempts
+				if err != nil {
+					report.Failed[kw] = err
+				}
 			}
+		}(batch)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("fetching SERPs stopped: %w", err)
+	}
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("%d of %d keywords failed", len(report.Failed), len(keywords))
+	}
+	return report, nil
+}
+
+// fetchBatch runs the batch SERP query for a set of keywords
+func (d Driver) fetchBatch(ctx context.Context, domainID int, keywords []string, opts QueryOptions) ([]SERPRow, error) {
+	q, args := opts.batchQuery(d.templates, domainID, keywords)
+	rows, cancel, err := d.query(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query database: %w", err)
+	}
+	defer cancel()
+	defer rows.Close()
+
+	c := d.templates.Columns
+	cols, err := newColumnScanner(rows, c.Keyword, c.Prominence, c.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []SERPRow
+	for rows.Next() {
+		var r SERPRow
+		err := cols.scan(rows, &r.Keyword, &r.Prominence, &r.Domain)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing row: %w", err)
 		}
+		result = append(result, r)
+	}
 
-		return nil
-	})
+	return result, rows.Err()
+}
+
+// chunk splits keywords into slices of at most size keywords
+func chunk(keywords []string, size int) [][]string {
+	if size <= 0 {
+		size = len(keywords)
+	}
+
+	var chunks [][]string
+	for start := 0; start < len(keywords); start += size {
+		end := start + size
+		if end > len(keywords) {
+			end = len(keywords)
+		}
+		chunks = append(chunks, keywords[start:end])
+	}
+
+	return chunks
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
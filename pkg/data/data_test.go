@@ -0,0 +1,401 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestFetchSERPWithOptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	d, _ := New(WithDB(db))
+	opts := DefaultQueryOptions()
+	opts.MarketIDs = []int{1, 3}
+	opts.From = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	opts.To = time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)
+	opts.MaxRank = 10
+	opts.Limit = 5
+
+	mock.ExpectQuery(`AND name = \$2\s+AND market_id = ANY\(\$3\)\s+AND date >= \$4\s+AND date <= \$5.*avg_rank <= \$6.*LIMIT \$7`).
+		WithArgs(6290, "condo parking", pq.Array([]int64{1, 3}), opts.From, opts.To, 10, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"keyword", "prominence", "competitor"}).
+			AddRow("condo parking", 1, "a.com").
+			AddRow("condo parking", 2, "b.com"))
+
+	var domains []string
+	err = d.FetchSERP(context.Background(), 6290, "condo parking", opts, func(rows *sql.Rows) error {
+		var kw, domain string
+		var prominence int
+		if err := rows.Scan(&kw, &prominence, &domain); err != nil {
+			return err
+		}
+		domains = append(domains, domain)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(domains, []string{"a.com", "b.com"}) {
+		t.Errorf("expected [a.com b.com], got %v", domains)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestFetchKeywordsDefaultOptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	d, _ := New(WithDB(db))
+	mock.ExpectQuery(`WHERE domain_id = \$1\s*$`).
+		WithArgs(6290).
+		WillReturnRows(sqlmock.NewRows([]string{"keyword"}).
+			AddRow("condo parking").
+			AddRow("park share"))
+
+	keywords, err := d.FetchKeywords(context.Background(), 6290, DefaultQueryOptions())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(keywords, []string{"condo parking", "park share"}) {
+		t.Errorf("unexpected keywords %v", keywords)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestQueryOptionsValidate(t *testing.T) {
+	day := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name  string
+		opts  func(o *QueryOptions)
+		valid bool
+	}{
+		{name: "defaults", opts: func(o *QueryOptions) {}, valid: true},
+		{name: "date and range", opts: func(o *QueryOptions) { o.Date = day; o.From = day }},
+		{name: "reversed range", opts: func(o *QueryOptions) { o.From = day; o.To = day.AddDate(0, 0, -1) }},
+		{name: "no limit", opts: func(o *QueryOptions) { o.Limit = 0 }},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			o := DefaultQueryOptions()
+			tc.opts(&o)
+			if err := o.Validate(); (err == nil) != tc.valid {
+				t.Errorf("expected valid=%t, got error %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestFetchSERPsInBatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	d, _ := New(WithDB(db), WithBatchSize(2), WithMaxInFlight(1))
+	columns := []string{"keyword", "prominence", "competitor"}
+	mock.ExpectQuery(`name = ANY\(\$2\).*PARTITION BY a.keyword`).
+		WithArgs(6290, pq.Array([]string{"a", "b"}), 20, 20).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("a", 1, "x.com").
+			AddRow("a", 2, "y.com").
+			AddRow("b", 1, "x.com"))
+	mock.ExpectQuery(`name = ANY\(\$2\)`).
+		WithArgs(6290, pq.Array([]string{"c"}), 20, 20).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	var batches [][]string
+	var rows []SERPRow
+	_, err = d.FetchSERPs(context.Background(), 6290, []string{"a", "b", "c"}, DefaultQueryOptions(), func(batch []string, r []SERPRow) error {
+		batches = append(batches, batch)
+		rows = append(rows, r...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(batches, [][]string{{"a", "b"}, {"c"}}) {
+		t.Errorf("unexpected batches %v", batches)
+	}
+	if l := len(rows); l != 3 {
+		t.Errorf("expected 3 rows, got %d", l)
+	}
+	if rows[1] != (SERPRow{Keyword: "a", Prominence: 2, Domain: "y.com"}) {
+		t.Errorf("unexpected row %+v", rows[1])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestFetchSERPsCancelled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	d, _ := New(WithDB(db))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = d.FetchSERPs(ctx, 6290, []string{"a"}, DefaultQueryOptions(), func([]string, []SERPRow) error {
+		t.Errorf("expected no batches after cancellation")
+		return nil
+	})
+	if err == nil {
+		t.Errorf("expected an error after cancellation")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	d, _ := New(WithDB(db), WithStatementTimeout(10*time.Millisecond))
+	mock.ExpectQuery(`SELECT DISTINCT name`).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"keyword"}))
+
+	_, err = d.FetchKeywords(context.Background(), 6290, DefaultQueryOptions())
+	if err == nil {
+		t.Errorf("expected the query to time out")
+	}
+}
+
+func TestConnString(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		driver   Driver
+		expected string
+	}{
+		{
+			name:     "discrete fields",
+			driver:   Driver{User: "kcf", Password: "p@ss w'rd\\", Host: "db.internal", Port: 6432, Database: "product"},
+			expected: `host='db.internal' port='6432' user='kcf' password='p@ss w\'rd\\' dbname='product'`,
+		},
+		{
+			name:     "host with port",
+			driver:   Driver{Host: "db.internal:6432"},
+			expected: `host='db.internal' port='6432'`,
+		},
+		{
+			name:     "TLS and application name",
+			driver:   Driver{Host: "db", SSLMode: "verify-full", SSLRootCert: "/etc/ssl/ca.pem", ApplicationName: "kcf"},
+			expected: `host='db' sslmode='verify-full' sslrootcert='/etc/ssl/ca.pem' application_name='kcf'`,
+		},
+		{
+			name:     "URL DSN overridden by fields",
+			driver:   Driver{DSN: "postgres://kcf:secret@db:5432/product?sslmode=require", Database: "staging"},
+			expected: `dbname=product host=db password=secret port=5432 sslmode=require user=kcf dbname='staging'`,
+		},
+		{
+			name:     "key=value DSN",
+			driver:   Driver{DSN: "host=db sslmode=disable", User: "kcf"},
+			expected: `host=db sslmode=disable user='kcf'`,
+		},
+		{
+			name:     "nothing set leaves it to the environment",
+			expected: ``,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := tc.driver.connString()
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if conn != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, conn)
+			}
+		})
+	}
+}
+
+func TestConnStringInvalid(t *testing.T) {
+	for _, d := range []Driver{
+		{SSLMode: "prefer"},
+		{DSN: "postgres://db:notaport/product"},
+	} {
+		if _, err := d.connString(); err == nil {
+			t.Errorf("expected an error for %+v", d)
+		}
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	// Keep the environment out of it, apart from where it's the point
+	vars := []string{"PGUSER", "PGHOST", "PGPORT", "PGDATABASE"}
+	saved := make(map[string]string)
+	for _, v := range vars {
+		saved[v] = os.Getenv(v)
+		os.Unsetenv(v)
+	}
+	defer func() {
+		for _, v := range vars {
+			os.Setenv(v, saved[v])
+		}
+	}()
+
+	for _, tc := range []struct {
+		name     string
+		driver   Driver
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "URL DSN without its password",
+			driver:   Driver{DSN: "postgres://kcf:secret@db:5433/product"},
+			expected: "kcf@db:5433/product",
+		},
+		{
+			name:     "fields override the DSN",
+			driver:   Driver{DSN: "host=db dbname=product user=kcf", Database: "it's staging"},
+			expected: "kcf@db:5432/it's staging",
+		},
+		{
+			name:     "environment fills the gaps",
+			driver:   Driver{Host: "warehouse:6543"},
+			env:      map[string]string{"PGUSER": "etl", "PGDATABASE": "dw"},
+			expected: "etl@warehouse:6543/dw",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			id, err := tc.driver.Identity()
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if id != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, id)
+			}
+		})
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dir = filepath.Join(dir, "warehouse")
+	os.Mkdir(dir, 0755)
+	for name, content := range map[string]string{
+		KeywordsTemplateFile:  "SELECT kw FROM tracked WHERE site = $1{{filters}}",
+		BatchSERPTemplateFile: "SELECT * FROM serps WHERE site = $1 AND kw = ANY($2){{filters}} AND pos <= {{max_rank}} LIMIT {{limit}}",
+		ColumnsFile:           `{"market": "region", "keyword": "kw", "prominence": "pos", "domain": "host"}`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("could not write %s: %v", name, err)
+		}
+	}
+
+	tmpl, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if tmpl.Name != "warehouse" {
+		t.Errorf("expected the set to be named after its directory, got %s", tmpl.Name)
+	}
+	if tmpl.Columns.Date != "date" || tmpl.Columns.Market != "region" {
+		t.Errorf("expected default columns to be overridden selectively, got %+v", tmpl.Columns)
+	}
+
+	os.Remove(filepath.Join(dir, BatchSERPTemplateFile))
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Errorf("expected an error without a batch SERP query")
+	}
+}
+
+func TestCustomTemplates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	tmpl := Templates{
+		Name:      "warehouse",
+		Keywords:  "SELECT kw FROM tracked WHERE site = $1{{filters}}",
+		BatchSERP: "SELECT * FROM serps WHERE site = $1 AND kw = ANY($2){{filters}} AND pos <= {{max_rank}} LIMIT {{limit}}",
+		Columns: Columns{
+			Market:     "region",
+			Date:       "day",
+			Keyword:    "kw",
+			Prominence: "pos",
+			Domain:     "host",
+		},
+	}
+	d, err := New(WithDB(db), WithTemplates(tmpl))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	opts := DefaultQueryOptions()
+	opts.MarketIDs = []int{1}
+	mock.ExpectQuery(`FROM tracked WHERE site = \$1\s+AND region = ANY\(\$2\)`).
+		WithArgs(6290, pq.Array([]int64{1})).
+		WillReturnRows(sqlmock.NewRows([]string{"kw"}).AddRow("condo parking"))
+	mock.ExpectQuery(`FROM serps WHERE site = \$1 AND kw = ANY\(\$2\)\s+AND region = ANY\(\$3\) AND pos <= \$4 LIMIT \$5`).
+		WithArgs(6290, pq.Array([]string{"condo parking"}), pq.Array([]int64{1}), 20, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"host", "score", "pos", "kw"}).
+			AddRow("a.com", 0.9, 1, "condo parking").
+			AddRow("b.com", 0.4, 2, "condo parking"))
+
+	keywords, err := d.FetchKeywords(context.Background(), 6290, opts)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var rows []SERPRow
+	_, err = d.FetchSERPs(context.Background(), 6290, keywords, opts, func(batch []string, r []SERPRow) error {
+		rows = append(rows, r...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := []SERPRow{
+		{Keyword: "condo parking", Prominence: 1, Domain: "a.com"},
+		{Keyword: "condo parking", Prominence: 2, Domain: "b.com"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
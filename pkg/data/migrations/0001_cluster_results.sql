@@ -0,0 +1,44 @@
+-- Tables recording clustering runs, written by data.Driver.SaveRun
+
+CREATE TABLE IF NOT EXISTS cluster_runs (
+	run_id        BIGSERIAL PRIMARY KEY,
+	domain_id     INTEGER NOT NULL,
+	created_at    TIMESTAMPTZ NOT NULL,
+	-- Clustering parameters such as the RBO p value and MCL inflation
+	parameters    JSONB NOT NULL,
+	-- Markets, dates, rank cutoff and limit the SERPs were fetched with
+	query         JSONB NOT NULL,
+	keyword_count INTEGER NOT NULL,
+	cluster_count INTEGER NOT NULL,
+	-- Mean cohesion over all clustered keywords, weighting clusters by size
+	mean_cohesion DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS cluster_runs_domain ON cluster_runs (domain_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS clusters (
+	run_id     BIGINT NOT NULL REFERENCES cluster_runs (run_id) ON DELETE CASCADE,
+	-- Stable across runs of the same domain, such as c12
+	cluster_id TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	size       INTEGER NOT NULL,
+	cohesion   DOUBLE PRECISION NOT NULL,
+	-- NULL if volatility was not measured for the run
+	volatility DOUBLE PRECISION,
+	PRIMARY KEY (run_id, cluster_id)
+);
+
+CREATE TABLE IF NOT EXISTS cluster_memberships (
+	run_id     BIGINT NOT NULL,
+	cluster_id TEXT NOT NULL,
+	keyword    TEXT NOT NULL,
+	-- Each keyword has one primary cluster, and may also be a secondary
+	-- member of others it has a high affinity to
+	is_primary BOOLEAN NOT NULL,
+	-- NULL for primary members
+	affinity   DOUBLE PRECISION,
+	PRIMARY KEY (run_id, cluster_id, keyword),
+	FOREIGN KEY (run_id, cluster_id) REFERENCES clusters (run_id, cluster_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS cluster_memberships_keyword ON cluster_memberships (run_id, keyword);
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// QueryOptions narrows down the rankings considered when fetching keywords and
+// SERPs
+type QueryOptions struct {
+	// MarketIDs limits rankings to the given markets. Leave empty to use all
+	// markets
+	MarketIDs []int `json:"market_ids,omitempty"`
+	// Date limits rankings to a single date. Leave zero to aggregate rankings
+	// across every date, or set From and To instead for a range of dates
+	Date time.Time `json:"date"`
+	// From and To limit rankings to a range of dates, inclusive. Either may be
+	// left zero to leave that end of the range open
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// MaxRank is the worst average rank a competitor may have to be considered
+	MaxRank int `json:"max_rank"`
+	// Limit is the maximum number of competitors in a SERP
+	Limit int `json:"limit"`
+}
+
+// DefaultQueryOptions returns the options used when none are specified: all
+// markets, rankings from every date, and the top 20 competitors ranking within
+// the top 20 results
+func DefaultQueryOptions() QueryOptions {
+	return QueryOptions{
+		MaxRank: 20,
+		Limit:   20,
+	}
+}
+
+// Validate checks the options are consistent
+func (o QueryOptions) Validate() error {
+	if !o.Date.IsZero() && (!o.From.IsZero() || !o.To.IsZero()) {
+		return fmt.Errorf("cannot combine a single date with a date range")
+	}
+	if !o.From.IsZero() && !o.To.IsZero() && o.To.Before(o.From) {
+		return fmt.Errorf("date range ends before it starts")
+	}
+	if o.MaxRank <= 0 {
+		return fmt.Errorf("max rank must be positive")
+	}
+	if o.Limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+
+	return nil
+}
+
+// filters builds the SQL conditions for the market and date options, to be
+// appended to a WHERE clause on the given columns. args holds the query
+// arguments used so far, and the arguments for the conditions are appended to
+// it
+func (o QueryOptions) filters(cols Columns, args []interface{}) (string, []interface{}) {
+	var conds []string
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if len(o.MarketIDs) > 0 {
+		ids := make([]int64, len(o.MarketIDs))
+		for i, id := range o.MarketIDs {
+			ids[i] = int64(id)
+		}
+		add(cols.Market+" = ANY($%d)", pq.Array(ids))
+	}
+	if !o.Date.IsZero() {
+		add(cols.Date+" = $%d", o.Date)
+	}
+	if !o.From.IsZero() {
+		add(cols.Date+" >= $%d", o.From)
+	}
+	if !o.To.IsZero() {
+		add(cols.Date+" <= $%d", o.To)
+	}
+
+	var sql string
+	for _, cond := range conds {
+		sql += "\n\t\tAND " + cond
+	}
+	return sql, args
+}
+
+// serpQuery builds the SERP query and its arguments for a keyword, falling
+// back to the batch query if the templates have no single-keyword query
+func (o QueryOptions) serpQuery(t Templates, domainID int, keyword string) (string, []interface{}) {
+	if t.SERP == "" {
+		return o.batchQuery(t, domainID, []string{keyword})
+	}
+	return o.render(t.SERP, t.Columns, []interface{}{domainID, keyword})
+}
+
+// batchQuery builds the batch SERP query and its arguments for a set of
+// keywords
+func (o QueryOptions) batchQuery(t Templates, domainID int, keywords []string) (string, []interface{}) {
+	return o.render(t.BatchSERP, t.Columns, []interface{}{domainID, pq.Array(keywords)})
+}
+
+// render fills in the filter, rank and limit placeholders of a SERP query
+// template, appending their arguments to args
+func (o QueryOptions) render(template string, cols Columns, args []interface{}) (string, []interface{}) {
+	filters, args := o.filters(cols, args)
+	args = append(args, o.MaxRank, o.Limit)
+
+	r := strings.NewReplacer(
+		"{{filters}}", filters,
+		"{{max_rank}}", fmt.Sprintf("$%d", len(args)-1),
+		"{{limit}}", fmt.Sprintf("$%d", len(args)),
+	)
+	return r.Replace(template), args
+}
+
+// keywordsQuery builds the keywords query and its arguments for a domain
+func (o QueryOptions) keywordsQuery(t Templates, domainID int) (string, []interface{}) {
+	filters, args := o.filters(t.Columns, []interface{}{domainID})
+	return strings.Replace(t.Keywords, "{{filters}}", filters, 1), args
+}
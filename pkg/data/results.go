@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Run is a clustering run to record with SaveRun. The tables it is written to
+// are created by migrations/0001_cluster_results.sql
+type Run struct {
+	DomainID int
+	Created  time.Time
+	// Parameters holds the clustering parameters encoded as JSON
+	Parameters json.RawMessage
+	Query      QueryOptions
+	Clusters   []ClusterResult
+}
+
+// ClusterResult is a cluster found by a run
+type ClusterResult struct {
+	// ID must be set and unique within the run
+	ID       string
+	Name     string
+	Keywords []string
+	Cohesion float64
+	// Volatility is nil if it was not measured
+	Volatility *float64
+	Secondary  []SecondaryMember
+}
+
+// SecondaryMember is a keyword assigned to another cluster that could also be
+// targeted from this one
+type SecondaryMember struct {
+	Keyword  string
+	Affinity float64
+}
+
+// Metrics summarizes the quality of the run's clusters: how many keywords were
+// clustered into how many clusters, and their cohesion weighted by size
+func (r Run) Metrics() (keywords int, clusters int, meanCohesion float64) {
+	for _, c := range r.Clusters {
+		keywords += len(c.Keywords)
+		meanCohesion += c.Cohesion * float64(len(c.Keywords))
+	}
+	if keywords > 0 {
+		meanCohesion /= float64(keywords)
+	}
+	return keywords, len(r.Clusters), meanCohesion
+}
+
+// validate checks every cluster can be identified
+func (r Run) validate() error {
+	ids := make(map[string]bool)
+	for _, c := range r.Clusters {
+		if c.ID == "" {
+			return fmt.Errorf("cluster '%s' has no ID", c.Name)
+		}
+		if ids[c.ID] {
+			return fmt.Errorf("cluster ID %s is used more than once", c.ID)
+		}
+		ids[c.ID] = true
+	}
+	return nil
+}
+
+// SaveRun records a clustering run, its clusters and their keyword
+// memberships in a single transaction, so readers never see a partial run.
+// The transaction is retried as a whole on transient errors, other than while
+// committing. It returns the ID of the new run
+func (d Driver) SaveRun(ctx context.Context, run Run) (int64, error) {
+	if err := run.validate(); err != nil {
+		return 0, fmt.Errorf("invalid run: %v", err)
+	}
+
+	var runID int64
+	_, err := d.retryPolicy.retry(ctx, func() error {
+		var err error
+		runID, err = d.saveRun(ctx, run)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not save run: %w", err)
+	}
+	return runID, nil
+}
+
+// saveRun runs the transaction recording a run
+func (d Driver) saveRun(ctx context.Context, run Run) (int64, error) {
+	if d.statementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.statementTimeout)
+		defer cancel()
+	}
+
+	query, err := json.Marshal(run.Query)
+	if err != nil {
+		return 0, fmt.Errorf("could not encode query options: %v", err)
+	}
+	parameters := run.Parameters
+	if parameters == nil {
+		parameters = json.RawMessage("{}")
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	keywords, clusters, cohesion := run.Metrics()
+	var runID int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO cluster_runs (domain_id, created_at, parameters, query, keyword_count, cluster_count, mean_cohesion)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING run_id`,
+		run.DomainID, run.Created, []byte(parameters), query, keywords, clusters, cohesion,
+	).Scan(&runID)
+	if err != nil {
+		return 0, fmt.Errorf("could not insert run: %w", err)
+	}
+
+	insertCluster, err := tx.PrepareContext(ctx, `
+		INSERT INTO clusters (run_id, cluster_id, name, size, cohesion, volatility)
+		VALUES ($1, $2, $3, $4, $5, $6)`)
+	if err != nil {
+		return 0, fmt.Errorf("could not prepare cluster insert: %w", err)
+	}
+	defer insertCluster.Close()
+
+	insertMember, err := tx.PrepareContext(ctx, `
+		INSERT INTO cluster_memberships (run_id, cluster_id, keyword, is_primary, affinity)
+		VALUES ($1, $2, $3, $4, $5)`)
+	if err != nil {
+		return 0, fmt.Errorf("could not prepare membership insert: %w", err)
+	}
+	defer insertMember.Close()
+
+	for _, c := range run.Clusters {
+		var volatility sql.NullFloat64
+		if c.Volatility != nil {
+			volatility = sql.NullFloat64{Float64: *c.Volatility, Valid: true}
+		}
+		_, err := insertCluster.ExecContext(ctx, runID, c.ID, c.Name, len(c.Keywords), c.Cohesion, volatility)
+		if err != nil {
+			return 0, fmt.Errorf("could not insert cluster %s: %w", c.ID, err)
+		}
+
+		for _, kw := range c.Keywords {
+			_, err := insertMember.ExecContext(ctx, runID, c.ID, kw, true, nil)
+			if err != nil {
+				return 0, fmt.Errorf("could not insert membership of %s in %s: %w", kw, c.ID, err)
+			}
+		}
+		for _, m := range c.Secondary {
+			_, err := insertMember.ExecContext(ctx, runID, c.ID, m.Keyword, false, m.Affinity)
+			if err != nil {
+				return 0, fmt.Errorf("could not insert membership of %s in %s: %w", m.Keyword, c.ID, err)
+			}
+		}
+	}
+
+	// A commit that fails may still have been applied, so don't let it be
+	// retried and record the run twice
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("could not commit run: %v", err)
+	}
+	return runID, nil
+}
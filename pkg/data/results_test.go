@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func testRun() Run {
+	volatility := 0.25
+	return Run{
+		DomainID:   6290,
+		Created:    time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC),
+		Parameters: json.RawMessage(`{"rbo_p":0.9}`),
+		Query:      DefaultQueryOptions(),
+		Clusters: []ClusterResult{
+			{ID: "c1", Name: "condo parking", Keywords: []string{"condo parking", "condo garage", "condo spot"}, Cohesion: 0.6, Volatility: &volatility},
+			{ID: "c2", Name: "park share", Keywords: []string{"park share"}, Cohesion: 1, Secondary: []SecondaryMember{{Keyword: "condo spot", Affinity: 0.55}}},
+		},
+	}
+}
+
+func TestSaveRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	d, _ := New(WithDB(db))
+	run := testRun()
+	query, _ := json.Marshal(run.Query)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO cluster_runs`).
+		WithArgs(6290, run.Created, []byte(run.Parameters), query, 4, 2, 0.7).
+		WillReturnRows(sqlmock.NewRows([]string{"run_id"}).AddRow(42))
+	clusters := mock.ExpectPrepare(`INSERT INTO clusters`)
+	members := mock.ExpectPrepare(`INSERT INTO cluster_memberships`)
+	clusters.ExpectExec().WithArgs(42, "c1", "condo parking", 3, 0.6, sql.NullFloat64{Float64: 0.25, Valid: true}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, kw := range run.Clusters[0].Keywords {
+		members.ExpectExec().WithArgs(42, "c1", kw, true, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	clusters.ExpectExec().WithArgs(42, "c2", "park share", 1, 1.0, sql.NullFloat64{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	members.ExpectExec().WithArgs(42, "c2", "park share", true, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	members.ExpectExec().WithArgs(42, "c2", "condo spot", false, 0.55).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	runID, err := d.SaveRun(context.Background(), run)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if runID != 42 {
+		t.Errorf("expected run 42, got %d", runID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestSaveRunRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	d, _ := New(WithDB(db), WithRetryPolicy(policy))
+
+	// A serialization failure partway through retries the whole transaction
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO cluster_runs`).WillReturnRows(sqlmock.NewRows([]string{"run_id"}).AddRow(1))
+	clusters := mock.ExpectPrepare(`INSERT INTO clusters`)
+	mock.ExpectPrepare(`INSERT INTO cluster_memberships`)
+	clusters.ExpectExec().WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectRollback()
+
+	// An error that isn't transient gives up
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO cluster_runs`).WillReturnError(errors.New("relation \"cluster_runs\" does not exist"))
+	mock.ExpectRollback()
+
+	_, err = d.SaveRun(context.Background(), testRun())
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestSaveRunRequiresIDs(t *testing.T) {
+	run := testRun()
+	run.Clusters[1].ID = "c1"
+	if _, err := (Driver{}).SaveRun(context.Background(), run); err == nil {
+		t.Errorf("expected an error for duplicate cluster IDs")
+	}
+}
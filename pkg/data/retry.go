@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RetryPolicy controls how failed queries are retried. Each retry waits
+// exponentially longer than the one before, up to MaxBackoff, with a random
+// jitter so concurrent queries don't retry in lockstep
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times to run a query, including the
+	// first. 1 disables retries
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of each backoff that is randomized, from 0 for
+	// none to 1 for anywhere between 0 and the full backoff
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+// Backoff computes how long to wait after the given attempt, starting at 1,
+// has failed
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if max := float64(p.MaxBackoff); p.MaxBackoff > 0 && backoff > max {
+		backoff = max
+	}
+
+	jitter := math.Max(0, math.Min(1, p.Jitter))
+	backoff -= backoff * jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// retryableCodes lists the Postgres error codes worth retrying, keyed by code
+// or, for whole classes of errors, by the two-character class
+var retryableCodes = map[string]bool{
+	"08":    true, // connection exceptions
+	"40001": true, // serialization failure
+	"40P01": true, // deadlock detected
+	"53300": true, // too many connections
+	"57P01": true, // admin shutdown
+	"57P02": true, // crash shutdown
+	"57P03": true, // cannot connect now
+	"57014": true, // query cancelled, which is how server-side timeouts appear
+}
+
+// IsRetryable reports whether an error is likely transient, so running the
+// query again may succeed: dropped or refused connections, serialization
+// failures, and timeouts
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		code := string(pqErr.Code)
+		return retryableCodes[code] || (len(code) >= 2 && retryableCodes[code[:2]])
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// retry runs operation until it succeeds, fails with an error that is not
+// retryable, or runs out of attempts, returning the number of attempts made
+// and the last error. It stops waiting as soon as ctx is done
+func (p RetryPolicy) retry(ctx context.Context, operation func() error) (int, error) {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = operation()
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !IsRetryable(err) {
+			return attempt, err
+		}
+
+		select {
+		case <-time.After(p.Backoff(attempt)):
+		case <-ctx.Done():
+			return attempt, err
+		}
+	}
+}
+
+// FetchReport accounts for the attempts made to fetch each keyword and which
+// keywords could not be fetched at all
+type FetchReport struct {
+	Attempts map[string]int
+	Failed   map[string]error
+}
+
+// NewFetchReport creates an empty FetchReport
+func NewFetchReport() FetchReport {
+	return FetchReport{
+		Attempts: make(map[string]int),
+		Failed:   make(map[string]error),
+	}
+}
+
+// FailedKeywords lists the keywords that permanently failed, in alphabetical
+// order
+func (r FetchReport) FailedKeywords() []string {
+	keywords := make([]string, 0, len(r.Failed))
+	for kw := range r.Failed {
+		keywords = append(keywords, kw)
+	}
+	sort.Strings(keywords)
+
+	return keywords
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tt := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil, expected: false},
+		{name: "serialization failure", err: &pq.Error{Code: "40001"}, expected: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, expected: true},
+		{name: "syntax error", err: &pq.Error{Code: "42601"}, expected: false},
+		{name: "wrapped connection reset", err: fmt.Errorf("query: %w", syscall.ECONNRESET), expected: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, expected: true},
+		{name: "timeout", err: context.DeadlineExceeded, expected: true},
+		{name: "cancelled", err: context.Canceled, expected: false},
+		{name: "other", err: errors.New("boom"), expected: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsRetryable(tc.err); got != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+
+	tt := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 3, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+	}
+
+	for _, tc := range tt {
+		for i := 0; i < 20; i++ {
+			if b := p.Backoff(tc.attempt); b < tc.min || b > tc.max {
+				t.Errorf("attempt %d: expected backoff in [%v, %v], got %v", tc.attempt, tc.min, tc.max, b)
+			}
+		}
+	}
+}
+
+func TestFetchSERPsRetries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 1}
+	d, _ := New(WithDB(db), WithBatchSize(1), WithMaxInFlight(1), WithRetryPolicy(policy))
+	columns := []string{"keyword", "prominence", "competitor"}
+
+	// "a" succeeds on its second attempt
+	mock.ExpectQuery(`name = ANY`).
+		WithArgs(6290, pq.Array([]string{"a"}), 20, 20).
+		WillReturnError(&pq.Error{Code: "40001"})
+	mock.ExpectQuery(`name = ANY`).
+		WithArgs(6290, pq.Array([]string{"a"}), 20, 20).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("a", 1, "x.com"))
+	// "b" fails with an error that is not worth retrying
+	mock.ExpectQuery(`name = ANY`).
+		WithArgs(6290, pq.Array([]string{"b"}), 20, 20).
+		WillReturnError(&pq.Error{Code: "42601"})
+
+	var fetched []string
+	report, err := d.FetchSERPs(context.Background(), 6290, []string{"a", "b"}, DefaultQueryOptions(), func(batch []string, _ []SERPRow) error {
+		fetched = append(fetched, batch...)
+		return nil
+	})
+	if err == nil {
+		t.Errorf("expected an error for the failed keyword")
+	}
+
+	if len(fetched) != 1 || fetched[0] != "a" {
+		t.Errorf("expected only 'a' to be fetched, got %v", fetched)
+	}
+	if a := report.Attempts["a"]; a != 2 {
+		t.Errorf("expected 2 attempts for 'a', got %d", a)
+	}
+	if a := report.Attempts["b"]; a != 1 {
+		t.Errorf("expected 1 attempt for 'b', got %d", a)
+	}
+	if failed := report.FailedKeywords(); len(failed) != 1 || failed[0] != "b" {
+		t.Errorf("expected 'b' to fail, got %v", failed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package data
+
+import "context"
+
+// Source fetches keywords and their SERPs for a domain. Driver is the Source
+// backed by the product database; other implementations may cache or replace
+// it
+type Source interface {
+	// FetchKeywords loads the keywords for a given domain, limited to the
+	// markets and dates in opts
+	FetchKeywords(ctx context.Context, domainID int, opts QueryOptions) ([]string, error)
+	// FetchSERPs loads prominent SERP members for many keywords, calling
+	// eachBatch (never concurrently) with groups of keywords and their rows
+	// ordered by keyword and prominence. The report lists keywords that could
+	// not be fetched
+	FetchSERPs(ctx context.Context, domainID int, keywords []string, opts QueryOptions, eachBatch func(batch []string, rows []SERPRow) error) (FetchReport, error)
+}
+
+var _ Source = Driver{}
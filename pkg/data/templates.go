@@ -0,0 +1,192 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Template files read by LoadTemplates
+const (
+	KeywordsTemplateFile  = "keywords.sql"
+	SERPTemplateFile      = "serp.sql"
+	BatchSERPTemplateFile = "batch_serp.sql"
+	ColumnsFile           = "columns.json"
+)
+
+// Templates is a named set of queries for fetching keywords and SERPs, so
+// build-from-db can run against warehouses with a different schema than ours.
+//
+// Queries take the domain ID as $1. The SERP queries take the keyword as $2,
+// or an array of keywords for the batch query, and the SERP for each keyword
+// must be limited and ordered by prominence. Placeholders in the queries are
+// filled in from QueryOptions:
+//
+//	{{filters}}   conditions on market and date, each starting with AND
+//	{{max_rank}}  parameter holding the worst average rank to consider
+//	{{limit}}     parameter holding the maximum number of competitors per SERP
+type Templates struct {
+	Name string
+	// Keywords selects the keywords tracked for a domain
+	Keywords string
+	// SERP selects the SERP for a single keyword. If empty, the batch query is
+	// run with just that keyword
+	SERP string
+	// BatchSERP selects the SERPs for many keywords at once
+	BatchSERP string
+	Columns   Columns
+}
+
+// Columns maps the fields we need to the columns the queries use
+type Columns struct {
+	// Market and Date are the columns filtered on in {{filters}}
+	Market string `json:"market"`
+	Date   string `json:"date"`
+	// Keyword, Prominence and Domain are the result columns of the SERP
+	// queries. Keyword is also the result column of the keywords query
+	Keyword    string `json:"keyword"`
+	Prominence string `json:"prominence"`
+	Domain     string `json:"domain"`
+}
+
+// DefaultTemplates returns the queries for our product database
+func DefaultTemplates() Templates {
+	return Templates{
+		Name:      "default",
+		Keywords:  keywordsQuery,
+		SERP:      query,
+		BatchSERP: batchQuery,
+		Columns:   DefaultColumns(),
+	}
+}
+
+// DefaultColumns returns the columns used by the default templates
+func DefaultColumns() Columns {
+	return Columns{
+		Market:     "market_id",
+		Date:       "date",
+		Keyword:    "keyword",
+		Prominence: "prominence",
+		Domain:     "competitor",
+	}
+}
+
+// LoadTemplates reads a template set from a directory holding keywords.sql,
+// batch_serp.sql and optionally serp.sql and columns.json. The set is named
+// after the directory. Columns missing from columns.json keep their default
+// names
+func LoadTemplates(dir string) (Templates, error) {
+	t := Templates{
+		Name:    filepath.Base(filepath.Clean(dir)),
+		Columns: DefaultColumns(),
+	}
+
+	for _, f := range []struct {
+		name     string
+		dest     *string
+		required bool
+	}{
+		{KeywordsTemplateFile, &t.Keywords, true},
+		{SERPTemplateFile, &t.SERP, false},
+		{BatchSERPTemplateFile, &t.BatchSERP, true},
+	} {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, f.name))
+		if os.IsNotExist(err) && !f.required {
+			continue
+		}
+		if err != nil {
+			return t, fmt.Errorf("could not read template: %v", err)
+		}
+		*f.dest = string(raw)
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, ColumnsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return t, fmt.Errorf("could not read columns: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &t.Columns)
+		if err != nil {
+			return t, fmt.Errorf("could not parse columns: %v", err)
+		}
+	}
+
+	return t, t.Validate()
+}
+
+// Validate checks the template set has the queries and columns it needs
+func (t Templates) Validate() error {
+	if strings.TrimSpace(t.Keywords) == "" {
+		return fmt.Errorf("template set %s has no keywords query", t.Name)
+	}
+	if strings.TrimSpace(t.BatchSERP) == "" {
+		return fmt.Errorf("template set %s has no batch SERP query", t.Name)
+	}
+
+	for _, c := range []struct {
+		field string
+		name  string
+	}{
+		{"market", t.Columns.Market},
+		{"date", t.Columns.Date},
+		{"keyword", t.Columns.Keyword},
+		{"prominence", t.Columns.Prominence},
+		{"domain", t.Columns.Domain},
+	} {
+		if c.name == "" {
+			return fmt.Errorf("template set %s has no %s column", t.Name, c.field)
+		}
+	}
+
+	return nil
+}
+
+// columnScanner reads result columns by name rather than position, so
+// templates may return them in any order alongside other columns
+type columnScanner struct {
+	index []int
+	row   []interface{}
+}
+
+// newColumnScanner finds the named columns in rows
+func newColumnScanner(rows *sql.Rows, names ...string) (columnScanner, error) {
+	var s columnScanner
+	columns, err := rows.Columns()
+	if err != nil {
+		return s, err
+	}
+
+	s.row = make([]interface{}, len(columns))
+	for i := range s.row {
+		s.row[i] = new(interface{})
+	}
+
+	for _, name := range names {
+		found := -1
+		for i, c := range columns {
+			if c == name {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return s, fmt.Errorf("query results have no column '%s'", name)
+		}
+		s.index = append(s.index, found)
+	}
+
+	return s, nil
+}
+
+// scan reads the current row's named columns into dest, in the order the
+// names were given
+func (s columnScanner) scan(rows *sql.Rows, dest ...interface{}) error {
+	for i, d := range dest {
+		s.row[s.index[i]] = d
+	}
+	return rows.Scan(s.row...)
+}
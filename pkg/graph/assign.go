@@ -0,0 +1,96 @@
+package graph
+
+import "math"
+
+// assign solves the assignment problem for a rows x cols cost matrix using the
+// Hungarian algorithm, returning for each row the column assigned to it, or -1
+// if the row was left unassigned because there are more rows than columns
+func assign(cost [][]float64) []int {
+	rows := len(cost)
+	if rows == 0 {
+		return nil
+	}
+	cols := len(cost[0])
+
+	// The algorithm needs at least as many columns as rows, so pad the matrix
+	// with zero-cost dummy columns when necessary
+	n := rows
+	m := cols
+	if m < n {
+		m = n
+	}
+	at := func(i, j int) float64 {
+		if j >= cols {
+			return 0
+		}
+		return cost[i][j]
+	}
+
+	// u and v are the row and column potentials, p[j] is the row matched to
+	// column j, and way tracks the augmenting path. Index 0 is a sentinel so the
+	// matrix is addressed 1-based
+	u := make([]float64, n+1)
+	v := make([]float64, m+1)
+	p := make([]int, m+1)
+	way := make([]int, m+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, m+1)
+		used := make([]bool, m+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				cur := at(i0-1, j-1) - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	result := make([]int, rows)
+	for i := range result {
+		result[i] = -1
+	}
+	for j := 1; j <= m; j++ {
+		if p[j] != 0 && j <= cols {
+			result[p[j]-1] = j - 1
+		}
+	}
+
+	return result
+}
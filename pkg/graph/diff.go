@@ -0,0 +1,144 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Diff reports how clusters changed between two runs
+type Diff struct {
+	Mapping Mapping `json:"mapping"`
+	// Moved lists keywords whose primary cluster changed
+	Moved []Move `json:"moved"`
+	// Changes lists size and cohesion changes of clusters present in both runs
+	Changes []ClusterChange `json:"changes"`
+}
+
+// Move describes a keyword that changed clusters. An empty ID means the
+// keyword was not in any cluster in that run
+type Move struct {
+	Keyword string `json:"keyword"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// ClusterChange describes the change in a cluster present in both runs
+type ClusterChange struct {
+	ID             string  `json:"id"`
+	Name           string  `json:"name"`
+	SizeBefore     int     `json:"size_before"`
+	SizeAfter      int     `json:"size_after"`
+	CohesionBefore float64 `json:"cohesion_before"`
+	CohesionAfter  float64 `json:"cohesion_after"`
+}
+
+// Compare computes the differences between two runs. The later run's clusters
+// are matched to the earlier run's the same way AssignIDs does, so IDs in the
+// diff refer to the earlier run even if the later run was saved with IDs from
+// some other run. Neither output is modified
+func Compare(before, after *Output) Diff {
+	matched := *after
+	matched.Clusters = make([]ClusterGroup, len(after.Clusters))
+	copy(matched.Clusters, after.Clusters)
+	matched.AssignIDs(before)
+	after = &matched
+
+	d := Diff{Mapping: relate(before.Clusters, after.Clusters)}
+
+	beforeIDs := primaryIDs(before.Clusters)
+	afterIDs := primaryIDs(after.Clusters)
+	keywords := make(map[string]bool)
+	for kw := range beforeIDs {
+		keywords[kw] = true
+	}
+	for kw := range afterIDs {
+		keywords[kw] = true
+	}
+	for kw := range keywords {
+		if beforeIDs[kw] != afterIDs[kw] {
+			d.Moved = append(d.Moved, Move{Keyword: kw, From: beforeIDs[kw], To: afterIDs[kw]})
+		}
+	}
+	sort.Slice(d.Moved, func(i, j int) bool {
+		return d.Moved[i].Keyword < d.Moved[j].Keyword
+	})
+
+	beforeByID := make(map[string]ClusterGroup)
+	for _, c := range before.Clusters {
+		beforeByID[c.ID] = c
+	}
+	for _, c := range after.Clusters {
+		b, ok := beforeByID[c.ID]
+		if !ok {
+			continue
+		}
+		d.Changes = append(d.Changes, ClusterChange{
+			ID:             c.ID,
+			Name:           c.Name,
+			SizeBefore:     len(b.Keywords),
+			SizeAfter:      len(c.Keywords),
+			CohesionBefore: b.Cohesion,
+			CohesionAfter:  c.Cohesion,
+		})
+	}
+
+	return d
+}
+
+// WriteText prints the diff in a human-readable form
+func (d Diff) WriteText(w io.Writer) {
+	fmt.Fprintln(w, "cluster mapping:")
+	d.Mapping.WriteText(w)
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "cluster changes:")
+	for _, c := range d.Changes {
+		fmt.Fprintf(w, "\t%s '%s': size %d -> %d (%+d), cohesion %.2f -> %.2f (%+.2f)\n",
+			c.ID, c.Name,
+			c.SizeBefore, c.SizeAfter, c.SizeAfter-c.SizeBefore,
+			c.CohesionBefore, c.CohesionAfter, c.CohesionAfter-c.CohesionBefore)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "moved keywords:")
+	for _, m := range d.Moved {
+		fmt.Fprintf(w, "\t%s: %s -> %s\n", m.Keyword, orNone(m.From), orNone(m.To))
+	}
+}
+
+// WriteJSON prints the diff as JSON
+func (d Diff) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode diff: %v", err)
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("could not write diff: %v", err)
+	}
+	return nil
+}
+
+func orNone(id string) string {
+	if id == "" {
+		return "(none)"
+	}
+	return id
+}
+
+// primaryIDs maps each keyword to the ID of the first cluster containing it
+func primaryIDs(clusters []ClusterGroup) map[string]string {
+	ids := make(map[string]string)
+	for _, c := range clusters {
+		for _, kw := range c.Keywords {
+			if _, ok := ids[kw]; !ok {
+				ids[kw] = c.ID
+			}
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,44 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	before := &Output{
+		NextID: 3,
+		Clusters: []ClusterGroup{
+			{ID: "c1", Name: "a", Keywords: []string{"a", "b", "c"}, Cohesion: 0.5},
+			{ID: "c2", Name: "d", Keywords: []string{"d", "e"}, Cohesion: 0.4},
+		},
+	}
+	after := &Output{
+		Clusters: []ClusterGroup{
+			{Name: "d", Keywords: []string{"c", "d", "e"}, Cohesion: 0.3},
+			{Name: "a", Keywords: []string{"a", "b", "f"}, Cohesion: 0.6},
+		},
+	}
+
+	d := Compare(before, after)
+
+	if after.Clusters[0].ID != "" {
+		t.Errorf("expected after to be left unmodified, got ID %s", after.Clusters[0].ID)
+	}
+
+	expectedMoves := []Move{
+		{Keyword: "c", From: "c1", To: "c2"},
+		{Keyword: "f", From: "", To: "c1"},
+	}
+	if !reflect.DeepEqual(d.Moved, expectedMoves) {
+		t.Errorf("expected moves %v, got %v", expectedMoves, d.Moved)
+	}
+
+	expectedChanges := []ClusterChange{
+		{ID: "c2", Name: "d", SizeBefore: 2, SizeAfter: 3, CohesionBefore: 0.4, CohesionAfter: 0.3},
+		{ID: "c1", Name: "a", SizeBefore: 3, SizeAfter: 3, CohesionBefore: 0.5, CohesionAfter: 0.6},
+	}
+	if !reflect.DeepEqual(d.Changes, expectedChanges) {
+		t.Errorf("expected changes %v, got %v", expectedChanges, d.Changes)
+	}
+}
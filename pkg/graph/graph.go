@@ -2,10 +2,12 @@ package graph
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/jamesneve/go-markov-cluster/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/candidates"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
 	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
-	"github.com/thedahv/keyword-cluster-finder/pkg/rbo"
 )
 
 // Graph builds a network of keywords and their relationship to other keywords
@@ -15,6 +17,12 @@ type Graph struct {
 	clusterPower         int
 	clusterInflation     int
 	maxComputeIterations int
+	secondaryThreshold   float64
+	assignThreshold      float64
+	candidates           candidates.Generator
+	idf                  bool
+	stoplist             []string
+	progress             progress.Observer
 }
 
 // Option configures a graph
@@ -51,6 +59,61 @@ func WithClusterMaxIterations(i int) Option {
 	}
 }
 
+// WithSecondaryThreshold configures the minimum affinity a keyword must have
+// to a cluster other than its own to be listed as a secondary member of it. Set
+// it to 0 to disable secondary memberships
+func WithSecondaryThreshold(t float64) Option {
+	return func(g *Graph) {
+		g.secondaryThreshold = t
+	}
+}
+
+// WithAssignThreshold configures the minimum affinity a keyword added since a
+// previous run must have to an existing cluster to join it without
+// reclustering. See FindClustersIncrementally
+func WithAssignThreshold(t float64) Option {
+	return func(g *Graph) {
+		g.assignThreshold = t
+	}
+}
+
+// WithCandidates configures the graph to compute similarity only for the pairs
+// of keywords chosen by gen, rather than for every pair. Pairs left out have
+// no edge in the graph
+func WithCandidates(gen candidates.Generator) Option {
+	return func(g *Graph) {
+		g.candidates = gen
+	}
+}
+
+// WithIDF configures the graph to weight each domain's share of the overlap
+// between SERPs by its inverse document frequency across the keyword data, so
+// domains ranking for most keywords link them less than domains ranking for
+// few. See rbo.IDF
+func WithIDF() Option {
+	return func(g *Graph) {
+		g.idf = true
+	}
+}
+
+// WithStoplist configures the graph to leave the given domains out of every
+// SERP before comparing them, so generic authorities like wikipedia.org don't
+// link unrelated keywords
+func WithStoplist(domains ...string) Option {
+	return func(g *Graph) {
+		g.stoplist = append([]string(nil), domains...)
+		sort.Strings(g.stoplist)
+	}
+}
+
+// WithProgress configures the graph to report the similarity, clustering and
+// output stages to obs
+func WithProgress(obs progress.Observer) Option {
+	return func(g *Graph) {
+		g.progress = obs
+	}
+}
+
 // New creates a new Graph configured by options
 func New(options ...Option) *Graph {
 	g := &Graph{
@@ -58,6 +121,8 @@ func New(options ...Option) *Graph {
 		clusterPower:         2,
 		clusterInflation:     5,
 		maxComputeIterations: 100,
+		secondaryThreshold:   0.5,
+		assignThreshold:      0.5,
 	}
 
 	for _, o := range options {
@@ -70,57 +135,124 @@ func New(options ...Option) *Graph {
 // ClusterGroup is a cluster of highly-related keywords with respect to the
 // similarity of their SERP members
 type ClusterGroup struct {
-	Name     string
-	Keywords []string
+	// ID identifies the cluster across runs. It is empty until assigned by
+	// Output.AssignIDs
+	ID       string   `json:"id,omitempty"`
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords"`
+	// Cohesion is the mean similarity among the keywords in the cluster
+	Cohesion float64 `json:"cohesion"`
+	// Volatility is the mean SERP volatility of the keywords in the cluster
+	// over time, or nil if it was not measured
+	Volatility *float64 `json:"volatility,omitempty"`
+	// Secondary lists keywords assigned elsewhere that could also be targeted
+	// from this cluster, ordered by descending affinity
+	Secondary []Membership `json:"secondary,omitempty"`
 }
 
 // FindClusters adds gathered SERP data to a graph, computes the RBO weights
 // among all SERPs, and returns clusters of keywords whose SERP members are
-// similar
+// similar. Keywords with a high affinity to a cluster other than their own are
+// listed as secondary members of that cluster.
+//
+// Results are deterministic for the same input: clusters are ordered as
+// described by SortClusters
 func (g Graph) FindClusters(kd rankings.KeywordData) ([]ClusterGroup, error) {
-	_g := graph.NewGraph()
-	nodes := make(map[string]*graph.Node)
-	for keyword := range kd {
-		n := graph.NewNode(keyword)
-		_g.AddNode(&n)
-		nodes[keyword] = &n
+	sim, err := g.ComputeSimilarity(kd)
+	if err != nil {
+		return nil, err
 	}
 
-	for fromKeyword, fromSERP := range kd {
-		for toKeyword, toSERP := range kd {
-			if fromKeyword == toKeyword {
-				continue
-			}
+	return g.ClusterSimilarity(sim)
+}
 
-			_, _, rboExt, err := rbo.RBO(fromSERP, toSERP, g.rboPValue)
-			if err != nil {
-				return nil, fmt.Errorf("error computing %s->%s: %v", fromKeyword, toKeyword, err)
-			}
-			_g.AddEdge(nodes[fromKeyword], nodes[toKeyword], rboExt)
+// ClusterSimilarity clusters the keywords of a similarity matrix, either
+// computed by ComputeSimilarity or reloaded with LoadSimilarity, the same way
+// as FindClusters
+func (g Graph) ClusterSimilarity(sim Similarity) ([]ClusterGroup, error) {
+	obs := progress.OrNoop(g.progress)
+
+	// The clustering library only finds the edges reachable from the first
+	// keyword, so clustering a matrix with pairs left out, say by a candidate
+	// generator, would miss every other component. Components share no
+	// edges, so clustering each on its own gives the same clusters.
+	//
+	// A lone keyword can't form a cluster, since the library drops clusters
+	// of one
+	var groups [][]string
+	for _, group := range sim.Components() {
+		if len(group) > 1 {
+			groups = append(groups, group)
 		}
 	}
 
-	c, err := _g.GetClusters(g.clusterPower, g.clusterInflation, g.maxComputeIterations)
-	if err != nil {
-		return nil, fmt.Errorf("could not find graph clusters: %v", err)
+	// The clustering library doesn't report its iterations, so clustering
+	// advances a component at a time
+	obs.Start(progress.Clustering, len(groups))
+	var c [][]string
+	for _, group := range groups {
+		found, err := g.markovClusters(sim, group)
+		if err != nil {
+			obs.Finish(progress.Clustering)
+			return nil, fmt.Errorf("could not find graph clusters: %v", err)
+		}
+		c = append(c, found...)
+		obs.Advance(progress.Clustering, 1)
 	}
+	obs.Finish(progress.Clustering)
 
+	obs.Start(progress.Output, len(c))
+	defer obs.Finish(progress.Output)
 	var clusters []ClusterGroup
-	for _, cluster := range *c {
+	for _, cluster := range c {
 		name := getShortestKeyword(cluster)
 		clusters = append(clusters, ClusterGroup{
 			Name:     name,
 			Keywords: cluster,
+			Cohesion: Cohesion(sim, cluster),
 		})
+		obs.Advance(progress.Output, 1)
+	}
+
+	SortClusters(clusters)
+	if g.secondaryThreshold > 0 {
+		assignSecondary(clusters, sim, g.secondaryThreshold)
 	}
 
 	return clusters, nil
 }
 
+// markovClusters runs the Markov clustering over the given keywords of the
+// matrix
+func (g Graph) markovClusters(sim Similarity, keywords []string) ([][]string, error) {
+	_g := graph.NewGraph()
+	nodes := make(map[string]*graph.Node)
+	for _, keyword := range keywords {
+		n := graph.NewNode(keyword)
+		_g.AddNode(&n)
+		nodes[keyword] = &n
+	}
+
+	for i, fromKeyword := range keywords {
+		for _, toKeyword := range keywords[i+1:] {
+			if score, ok := sim[fromKeyword][toKeyword]; ok {
+				_g.AddEdge(nodes[fromKeyword], nodes[toKeyword], score)
+			}
+		}
+	}
+
+	c, err := _g.GetClusters(g.clusterPower, g.clusterInflation, g.maxComputeIterations)
+	if err != nil {
+		return nil, err
+	}
+	return *c, nil
+}
+
 func getShortestKeyword(keywords []string) string {
 	shortest := keywords[0]
 	for i := 1; i < len(keywords); i++ {
-		if len(keywords[i]) < len(shortest) {
+		if len(keywords[i]) < len(shortest) ||
+			(len(keywords[i]) == len(shortest) && keywords[i] < shortest) {
 			shortest = keywords[i]
 		}
 	}
@@ -0,0 +1,261 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// flowThreshold is the share of a cluster's keywords that must flow into (or
+// come from) another cluster for the two to be considered part of a merge or
+// split
+const flowThreshold = 0.5
+
+// Mapping reports how the clusters of a run relate to the clusters of a
+// previous run
+type Mapping struct {
+	// Continued lists clusters that kept the ID of a previous cluster
+	Continued []Continuation `json:"continued"`
+	// Merged lists current clusters formed mostly from several previous clusters
+	Merged []Merge `json:"merged"`
+	// Split lists previous clusters whose keywords now form several clusters
+	Split []Split `json:"split"`
+	// New lists IDs of current clusters with no counterpart in the previous run
+	New []string `json:"new"`
+	// Dissolved lists IDs of previous clusters with no counterpart in the
+	// current run
+	Dissolved []string `json:"dissolved"`
+}
+
+// Continuation describes a current cluster matched to a previous cluster
+type Continuation struct {
+	ID      string  `json:"id"`
+	Jaccard float64 `json:"jaccard"`
+}
+
+// Merge describes a current cluster made up of several previous clusters
+type Merge struct {
+	ID   string   `json:"id"`
+	From []string `json:"from"`
+}
+
+// Split describes a previous cluster broken up into several current clusters
+type Split struct {
+	ID   string   `json:"id"`
+	Into []string `json:"into"`
+}
+
+// SortClusters orders clusters deterministically: members alphabetically
+// within each cluster, and clusters by descending size and then by name
+func SortClusters(clusters []ClusterGroup) {
+	for _, c := range clusters {
+		sort.Strings(c.Keywords)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if len(clusters[i].Keywords) != len(clusters[j].Keywords) {
+			return len(clusters[i].Keywords) > len(clusters[j].Keywords)
+		}
+		return clusters[i].Name < clusters[j].Name
+	})
+}
+
+// AssignIDs gives each cluster in the output a stable ID. Clusters are paired
+// with clusters from the previous output so the total Jaccard overlap of their
+// keywords is as large as possible, and paired clusters inherit the previous
+// ID. Remaining clusters get fresh IDs that the previous run never used, and
+// secondary members record the ID of their primary cluster. Pass a nil previous
+// output to number clusters from scratch
+func (o *Output) AssignIDs(previous *Output) Mapping {
+	var prev []ClusterGroup
+	next := 1
+	if previous != nil {
+		prev = previous.Clusters
+		next = previous.NextID
+		if n := nextID(prev); n > next {
+			next = n
+		}
+	}
+	current := o.Clusters
+
+	matched := make([]int, len(current))
+	for i := range matched {
+		matched[i] = -1
+	}
+	if len(prev) > 0 && len(current) > 0 {
+		cost := make([][]float64, len(current))
+		for i, c := range current {
+			cost[i] = make([]float64, len(prev))
+			for j, p := range prev {
+				cost[i][j] = 1 - Jaccard(c.Keywords, p.Keywords)
+			}
+		}
+
+		for i, j := range assign(cost) {
+			if j < 0 || cost[i][j] >= 1 {
+				continue
+			}
+			matched[i] = j
+		}
+	}
+
+	for i := range current {
+		j := matched[i]
+		if j < 0 {
+			current[i].ID = formatID(next)
+			next++
+			continue
+		}
+		current[i].ID = prev[j].ID
+	}
+	o.NextID = next
+	o.assignPrimaryIDs()
+
+	return relate(prev, current)
+}
+
+// assignPrimaryIDs records the ID of each secondary member's primary cluster,
+// the first cluster listing the keyword as assignSecondary does
+func (o *Output) assignPrimaryIDs() {
+	ids := make(map[string]string)
+	for _, c := range o.Clusters {
+		for _, kw := range c.Keywords {
+			if _, ok := ids[kw]; !ok {
+				ids[kw] = c.ID
+			}
+		}
+	}
+	for _, c := range o.Clusters {
+		for i := range c.Secondary {
+			c.Secondary[i].PrimaryID = ids[c.Secondary[i].Keyword]
+		}
+	}
+}
+
+// relate builds the mapping between two sets of clusters whose IDs have
+// already been assigned, so clusters sharing an ID are the same cluster
+func relate(prev, current []ClusterGroup) Mapping {
+	var mapping Mapping
+
+	prevByID := make(map[string]ClusterGroup)
+	for _, p := range prev {
+		prevByID[p.ID] = p
+	}
+	currentIDs := make(map[string]bool)
+	for _, c := range current {
+		currentIDs[c.ID] = true
+		if p, ok := prevByID[c.ID]; ok {
+			mapping.Continued = append(mapping.Continued, Continuation{
+				ID:      c.ID,
+				Jaccard: Jaccard(c.Keywords, p.Keywords),
+			})
+		}
+	}
+
+	involvedCurrent := make(map[string]bool)
+	involvedPrev := make(map[string]bool)
+	for _, c := range current {
+		var from []string
+		for _, p := range prev {
+			if share(p.Keywords, c.Keywords) >= flowThreshold {
+				from = append(from, p.ID)
+			}
+		}
+		if len(from) > 1 {
+			mapping.Merged = append(mapping.Merged, Merge{ID: c.ID, From: from})
+			involvedCurrent[c.ID] = true
+			for _, id := range from {
+				involvedPrev[id] = true
+			}
+		}
+	}
+	for _, p := range prev {
+		var into []string
+		for _, c := range current {
+			if share(c.Keywords, p.Keywords) >= flowThreshold {
+				into = append(into, c.ID)
+			}
+		}
+		if len(into) > 1 {
+			mapping.Split = append(mapping.Split, Split{ID: p.ID, Into: into})
+			involvedPrev[p.ID] = true
+			for _, id := range into {
+				involvedCurrent[id] = true
+			}
+		}
+	}
+
+	for _, c := range current {
+		if _, ok := prevByID[c.ID]; !ok && !involvedCurrent[c.ID] {
+			mapping.New = append(mapping.New, c.ID)
+		}
+	}
+	for _, p := range prev {
+		if !currentIDs[p.ID] && !involvedPrev[p.ID] {
+			mapping.Dissolved = append(mapping.Dissolved, p.ID)
+		}
+	}
+
+	return mapping
+}
+
+// Jaccard computes the size of the intersection over the size of the union of
+// two sets of keywords
+func Jaccard(a, b []string) float64 {
+	union := make(map[string]bool)
+	for _, kw := range a {
+		union[kw] = true
+	}
+	var intersect int
+	for _, kw := range b {
+		if union[kw] {
+			intersect++
+		}
+		union[kw] = true
+	}
+
+	if len(union) == 0 {
+		return 0
+	}
+	return float64(intersect) / float64(len(union))
+}
+
+// share computes the proportion of the keywords in a that are also in b
+func share(a, b []string) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+
+	set := make(map[string]bool)
+	for _, kw := range b {
+		set[kw] = true
+	}
+	var n int
+	for _, kw := range a {
+		if set[kw] {
+			n++
+		}
+	}
+
+	return float64(n) / float64(len(a))
+}
+
+func formatID(n int) string {
+	return fmt.Sprintf("c%d", n)
+}
+
+// nextID finds the first unused ID number after those in the given clusters
+func nextID(clusters []ClusterGroup) int {
+	next := 1
+	for _, c := range clusters {
+		n, err := strconv.Atoi(strings.TrimPrefix(c.ID, "c"))
+		if err != nil {
+			continue
+		}
+		if n >= next {
+			next = n + 1
+		}
+	}
+
+	return next
+}
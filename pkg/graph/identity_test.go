@@ -0,0 +1,93 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssign(t *testing.T) {
+	cost := [][]float64{
+		{4, 1, 3},
+		{2, 0, 5},
+		{3, 2, 2},
+	}
+	if got := assign(cost); !reflect.DeepEqual(got, []int{1, 0, 2}) {
+		t.Errorf("expected [1 0 2], got %v", got)
+	}
+
+	tall := [][]float64{
+		{1},
+		{0},
+	}
+	if got := assign(tall); !reflect.DeepEqual(got, []int{-1, 0}) {
+		t.Errorf("expected [-1 0], got %v", got)
+	}
+}
+
+func TestAssignIDs(t *testing.T) {
+	previous := &Output{
+		NextID: 6,
+		Clusters: []ClusterGroup{
+			{ID: "c1", Name: "a", Keywords: []string{"a", "b", "c"}},
+			{ID: "c2", Name: "d", Keywords: []string{"d", "e"}},
+			{ID: "c3", Name: "f", Keywords: []string{"f", "g"}},
+			{ID: "c4", Name: "h", Keywords: []string{"h", "i", "j", "k"}},
+			{ID: "c5", Name: "x", Keywords: []string{"x", "y"}},
+		},
+	}
+	current := &Output{
+		Clusters: []ClusterGroup{
+			{Name: "a", Keywords: []string{"a", "b", "c", "z"}},
+			{Name: "d", Keywords: []string{"d", "e", "f", "g"}},
+			{Name: "h", Keywords: []string{"h", "i"}},
+			{Name: "j", Keywords: []string{"j", "k"}},
+			{Name: "m", Keywords: []string{"m", "n"}},
+		},
+	}
+
+	mapping := current.AssignIDs(previous)
+
+	var ids []string
+	for _, c := range current.Clusters {
+		ids = append(ids, c.ID)
+	}
+	if expected := []string{"c1", "c2", "c4", "c6", "c7"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected IDs %v, got %v", expected, ids)
+	}
+	if current.NextID != 8 {
+		t.Errorf("expected next ID 8, got %d", current.NextID)
+	}
+
+	if expected := []Merge{{ID: "c2", From: []string{"c2", "c3"}}}; !reflect.DeepEqual(mapping.Merged, expected) {
+		t.Errorf("expected merges %v, got %v", expected, mapping.Merged)
+	}
+	if expected := []Split{{ID: "c4", Into: []string{"c4", "c6"}}}; !reflect.DeepEqual(mapping.Split, expected) {
+		t.Errorf("expected splits %v, got %v", expected, mapping.Split)
+	}
+	if expected := []string{"c7"}; !reflect.DeepEqual(mapping.New, expected) {
+		t.Errorf("expected new %v, got %v", expected, mapping.New)
+	}
+	if expected := []string{"c5"}; !reflect.DeepEqual(mapping.Dissolved, expected) {
+		t.Errorf("expected dissolved %v, got %v", expected, mapping.Dissolved)
+	}
+}
+
+func TestSortClusters(t *testing.T) {
+	clusters := []ClusterGroup{
+		{Name: "b", Keywords: []string{"b", "a"}},
+		{Name: "c", Keywords: []string{"e", "c", "d"}},
+		{Name: "a", Keywords: []string{"a", "f"}},
+	}
+	SortClusters(clusters)
+
+	var names []string
+	for _, c := range clusters {
+		names = append(names, c.Name)
+	}
+	if expected := []string{"c", "a", "b"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected order %v, got %v", expected, names)
+	}
+	if expected := []string{"c", "d", "e"}; !reflect.DeepEqual(clusters[0].Keywords, expected) {
+		t.Errorf("expected sorted keywords %v, got %v", expected, clusters[0].Keywords)
+	}
+}
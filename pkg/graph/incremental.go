@@ -0,0 +1,277 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/candidates"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// Update is the result of clustering incrementally from a previous run
+type Update struct {
+	Output  *Output
+	Mapping Mapping
+	// Added and Removed list the keywords that are new since the previous run
+	// and the ones no longer tracked
+	Added   []string
+	Removed []string
+	// Assigned maps added keywords that joined an existing cluster to its ID
+	Assigned map[string]string
+	// Reclustered lists the keywords of the components clustered again
+	Reclustered []string
+	// Changed lists the IDs of clusters whose keywords are not exactly those of
+	// the previous cluster with the same ID, including new clusters
+	Changed []string
+}
+
+// UpdateSimilarity brings a matrix computed for earlier keyword data up to
+// date with kd: keywords no longer in kd are dropped, and RBO is computed only
+// for the pairs involving keywords new to the matrix, or only those pairs the
+// candidate generator chooses if the graph has one. SERPs of keywords already
+// in the matrix are assumed not to have changed, and with IDF weighting their
+// pairs keep the weights of the data they were computed from. Progress is
+// reported as the similarity stage
+func (g Graph) UpdateSimilarity(sim Similarity, kd rankings.KeywordData) (added, removed []string, err error) {
+	for _, keyword := range sim.Keywords() {
+		if _, ok := kd[keyword]; !ok {
+			removed = append(removed, keyword)
+			sim.remove(keyword)
+		}
+	}
+
+	keywords := kd.Keywords()
+	for _, keyword := range keywords {
+		if _, ok := sim[keyword]; !ok {
+			added = append(added, keyword)
+		}
+	}
+
+	for _, keyword := range added {
+		sim[keyword] = make(map[string]float64)
+	}
+	s := g.newScorer(kd)
+	if g.candidates != nil {
+		var pairs []candidates.Pair
+		for _, pair := range g.candidates.Candidates(s.kd) {
+			if isAdded(added, pair.A) || isAdded(added, pair.B) {
+				pairs = append(pairs, pair)
+			}
+		}
+		return added, removed, g.computePairs(sim, s, pairs)
+	}
+
+	obs := progress.OrNoop(g.progress)
+	existing := len(keywords) - len(added)
+	obs.Start(progress.Similarity, len(added)*existing+len(added)*(len(added)-1)/2)
+	defer obs.Finish(progress.Similarity)
+
+	for _, fromKeyword := range added {
+		pairs := 0
+		for _, toKeyword := range keywords {
+			if toKeyword == fromKeyword || (isAdded(added, toKeyword) && toKeyword < fromKeyword) {
+				continue
+			}
+			score, err := s.score(fromKeyword, toKeyword)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error computing %s->%s: %v", fromKeyword, toKeyword, err)
+			}
+			sim.Set(fromKeyword, toKeyword, score)
+			pairs++
+		}
+		obs.Advance(progress.Similarity, pairs)
+	}
+
+	return added, removed, nil
+}
+
+// isAdded reports whether keyword is in the sorted list of added keywords
+func isAdded(added []string, keyword string) bool {
+	i := sort.SearchStrings(added, keyword)
+	return i < len(added) && added[i] == keyword
+}
+
+// remove drops a keyword and all its pairs from the matrix
+func (s Similarity) remove(keyword string) {
+	for other := range s[keyword] {
+		delete(s[other], keyword)
+	}
+	delete(s, keyword)
+}
+
+// FindClustersIncrementally updates the clusters of a previous run for the
+// keywords now in kd, given the similarity matrix the previous run was
+// computed with. Rather than clustering from scratch:
+//
+//   - RBO is only computed for pairs involving added keywords, updating sim in
+//     place; see UpdateSimilarity
+//   - removed keywords are dropped from their clusters, and clusters left
+//     with a single keyword are dropped too, as a full run would not find them
+//   - an added keyword whose affinity to an existing cluster meets the assign
+//     threshold joins the cluster it has the highest affinity to
+//   - the remaining added keywords are clustered along with the rest of their
+//     connected component, keywords linked to them by a non-zero similarity,
+//     replacing the clusters in that component. Other clusters are kept
+//
+// Cluster IDs are carried over from the previous output as by AssignIDs, and
+// the update reports which clusters changed
+func (g Graph) FindClustersIncrementally(kd rankings.KeywordData, sim Similarity, previous *Output) (*Update, error) {
+	if previous == nil {
+		return nil, fmt.Errorf("a previous output is required")
+	}
+	if previous.Parameters.RBOPValue != g.rboPValue {
+		return nil, fmt.Errorf("previous run used RBO p %v, not %v", previous.Parameters.RBOPValue, g.rboPValue)
+	}
+	if previous.Parameters.Weighting != g.weightingName() {
+		return nil, fmt.Errorf("previous run used weighting '%s', not '%s'", previous.Parameters.Weighting, g.weightingName())
+	}
+
+	added, removed, err := g.UpdateSimilarity(sim, kd)
+	if err != nil {
+		return nil, err
+	}
+	update := &Update{
+		Added:    added,
+		Removed:  removed,
+		Assigned: make(map[string]string),
+	}
+
+	// Work on copies so the previous output is left as it was, for mapping
+	var clusters []ClusterGroup
+	for _, c := range previous.Clusters {
+		var keywords []string
+		for _, kw := range c.Keywords {
+			if _, ok := sim[kw]; ok {
+				keywords = append(keywords, kw)
+			}
+		}
+		if len(keywords) > 1 {
+			clusters = append(clusters, ClusterGroup{ID: c.ID, Keywords: keywords})
+		}
+	}
+
+	var assigned, unassigned []string
+	for _, kw := range added {
+		best, bestAffinity := -1, g.assignThreshold
+		for i, c := range clusters {
+			if affinity := Affinity(sim, kw, c.Keywords); affinity >= bestAffinity {
+				best, bestAffinity = i, affinity
+			}
+		}
+		if best < 0 {
+			unassigned = append(unassigned, kw)
+			continue
+		}
+		clusters[best].Keywords = append(clusters[best].Keywords, kw)
+		assigned = append(assigned, kw)
+	}
+
+	if len(unassigned) > 0 {
+		clusters, update.Reclustered, err = g.recluster(sim, clusters, unassigned)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range clusters {
+		clusters[i].ID = ""
+		clusters[i].Name = getShortestKeyword(clusters[i].Keywords)
+		clusters[i].Cohesion = Cohesion(sim, clusters[i].Keywords)
+	}
+	SortClusters(clusters)
+	if g.secondaryThreshold > 0 {
+		assignSecondary(clusters, sim, g.secondaryThreshold)
+	}
+
+	update.Output = g.NewOutput(clusters)
+	update.Mapping = update.Output.AssignIDs(previous)
+
+	// Reclustering may have replaced the cluster a keyword was assigned to,
+	// so look up where it ended up once IDs are final
+	ids := make(map[string]string)
+	for _, c := range clusters {
+		for _, kw := range c.Keywords {
+			ids[kw] = c.ID
+		}
+	}
+	for _, kw := range assigned {
+		if id, ok := ids[kw]; ok {
+			update.Assigned[kw] = id
+		}
+	}
+	for _, id := range update.Mapping.New {
+		update.Changed = append(update.Changed, id)
+	}
+	for _, c := range update.Mapping.Continued {
+		if c.Jaccard < 1 {
+			update.Changed = append(update.Changed, c.ID)
+		}
+	}
+	sort.Strings(update.Changed)
+
+	return update, nil
+}
+
+// recluster clusters the connected components of sim holding any of the given
+// keywords, replacing the clusters with keywords in those components. It
+// returns the new set of clusters and the keywords that were clustered again
+func (g Graph) recluster(sim Similarity, clusters []ClusterGroup, keywords []string) ([]ClusterGroup, []string, error) {
+	groups := sim.Components()
+	component := make(map[string]int)
+	for i, group := range groups {
+		for _, kw := range group {
+			component[kw] = i
+		}
+	}
+	affected := make(map[int]bool)
+	for _, kw := range keywords {
+		affected[component[kw]] = true
+	}
+
+	var kept []ClusterGroup
+	for _, c := range clusters {
+		touched := false
+		for _, kw := range c.Keywords {
+			touched = touched || affected[component[kw]]
+		}
+		if !touched {
+			kept = append(kept, c)
+		}
+	}
+
+	var reclustered []string
+	for i, group := range groups {
+		if !affected[i] {
+			continue
+		}
+		found, err := g.ClusterSimilarity(sim.subset(group))
+		if err != nil {
+			return nil, nil, err
+		}
+		kept = append(kept, found...)
+		reclustered = append(reclustered, group...)
+	}
+	sort.Strings(reclustered)
+
+	return kept, reclustered, nil
+}
+
+// subset copies the rows and pairs of the matrix among the given keywords
+func (s Similarity) subset(keywords []string) Similarity {
+	in := make(map[string]bool, len(keywords))
+	for _, kw := range keywords {
+		in[kw] = true
+	}
+
+	sub := NewSimilarity()
+	for _, kw := range keywords {
+		sub[kw] = make(map[string]float64)
+		for other, score := range s[kw] {
+			if in[other] {
+				sub[kw][other] = score
+			}
+		}
+	}
+	return sub
+}
@@ -0,0 +1,149 @@
+package graph
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+func TestFindClustersIncrementally(t *testing.T) {
+	g := New(WithClusterInflation(2))
+
+	// The previous run lacks one parking keyword, which should join the
+	// parking cluster, and a group of garden keywords, which should be
+	// clustered on their own
+	before := similarityFixture()
+	delete(before, "parking 3")
+	sim, err := g.ComputeSimilarity(before)
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+	clusters, err := g.ClusterSimilarity(sim)
+	if err != nil {
+		t.Fatalf("could not cluster: %v", err)
+	}
+	previous := g.NewOutput(clusters)
+	previous.AssignIDs(nil)
+	ids := make(map[string]string)
+	for _, c := range previous.Clusters {
+		ids[strings.Fields(c.Name)[0]] = c.ID
+	}
+	if ids["parking"] == "" || ids["condo"] == "" {
+		t.Fatalf("expected parking and condo clusters, got %v", previous.Clusters)
+	}
+
+	after := similarityFixture()
+	delete(after, "condo 3")
+	for i := 0; i < 3; i++ {
+		kw := fmt.Sprintf("garden %d", i)
+		after[kw] = rankings.SERP{Keyword: kw, Members: []rankings.SERPMember{
+			{Keyword: kw, Prominence: 1, Domain: "g.com"},
+			{Keyword: kw, Prominence: 2, Domain: "h.com"},
+			{Keyword: kw, Prominence: 3, Domain: fmt.Sprintf("g%d.com", i)},
+		}}
+	}
+
+	update, err := g.FindClustersIncrementally(after, sim, previous)
+	if err != nil {
+		t.Fatalf("could not cluster incrementally: %v", err)
+	}
+
+	full, err := g.ComputeSimilarity(after)
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+	if !reflect.DeepEqual(sim, full) {
+		t.Errorf("expected the updated matrix to equal one computed from scratch")
+	}
+
+	if got := strings.Join(update.Added, ","); got != "garden 0,garden 1,garden 2,parking 3" {
+		t.Errorf("unexpected added keywords %s", got)
+	}
+	if got := strings.Join(update.Removed, ","); got != "condo 3" {
+		t.Errorf("unexpected removed keywords %s", got)
+	}
+	if id := update.Assigned["parking 3"]; id != ids["parking"] {
+		t.Errorf("expected parking 3 to join cluster %s, got %v", ids["parking"], update.Assigned)
+	}
+	if got := strings.Join(update.Reclustered, ","); got != "garden 0,garden 1,garden 2" {
+		t.Errorf("expected only the garden component to be reclustered, got %s", got)
+	}
+
+	var garden string
+	for _, c := range update.Output.Clusters {
+		if strings.HasPrefix(c.Name, "garden") {
+			garden = c.ID
+		}
+	}
+	if garden == "" {
+		t.Fatalf("expected a garden cluster, got %v", update.Output.Clusters)
+	}
+	expected := []string{ids["condo"], garden, ids["parking"]}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(update.Changed, expected) {
+		t.Errorf("expected clusters %v to change, got %v", expected, update.Changed)
+	}
+
+	header := g.SimilarityHeader(after)
+	header.Incremental = true
+	if err := header.Check(*g, after); err == nil {
+		t.Errorf("expected a matrix updated incrementally to be refused")
+	}
+	if err := header.CheckParameters(*g); err != nil {
+		t.Errorf("expected a matrix updated incrementally to be usable incrementally, got %v", err)
+	}
+}
+
+func TestFindClustersIncrementallyRemoved(t *testing.T) {
+	g := New(WithClusterInflation(2))
+
+	sim, err := g.ComputeSimilarity(similarityFixture())
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+	clusters, err := g.ClusterSimilarity(sim)
+	if err != nil {
+		t.Fatalf("could not cluster: %v", err)
+	}
+	previous := g.NewOutput(clusters)
+	previous.AssignIDs(nil)
+
+	// Removing all but one condo keyword leaves a cluster a full run would
+	// not find
+	after := similarityFixture()
+	for i := 1; i < 4; i++ {
+		delete(after, fmt.Sprintf("condo %d", i))
+	}
+	update, err := g.FindClustersIncrementally(after, sim, previous)
+	if err != nil {
+		t.Fatalf("could not cluster incrementally: %v", err)
+	}
+
+	full, err := g.ClusterSimilarity(sim)
+	if err != nil {
+		t.Fatalf("could not cluster: %v", err)
+	}
+	var got, expected []string
+	for _, c := range update.Output.Clusters {
+		got = append(got, strings.Join(c.Keywords, ","))
+	}
+	for _, c := range full {
+		expected = append(expected, strings.Join(c.Keywords, ","))
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the clusters of a full run %v, got %v", expected, got)
+	}
+	for _, c := range update.Output.Clusters {
+		for _, kw := range c.Keywords {
+			if kw == "condo 0" {
+				t.Errorf("expected the cluster left with condo 0 alone to be dropped, got %v", c)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package graph
+
+import "sort"
+
+// Membership describes how strongly a keyword relates to a cluster it was not
+// assigned to by the Markov clustering
+type Membership struct {
+	Keyword string `json:"keyword"`
+	// Primary is the name of the cluster the keyword was assigned to, or empty
+	// if the keyword did not land in any cluster
+	Primary string `json:"primary"`
+	// PrimaryID is the stable ID of that cluster, set once IDs are assigned.
+	// Unlike names, IDs never collide
+	PrimaryID string  `json:"primary_id,omitempty"`
+	Affinity  float64 `json:"affinity"`
+}
+
+// Affinity computes the mean similarity between a keyword and the members of a
+// cluster, ignoring the keyword itself if it is a member
+func Affinity(sim Similarity, keyword string, cluster []string) float64 {
+	var sum float64
+	var n int
+	for _, member := range cluster {
+		if member == keyword {
+			continue
+		}
+		sum += sim.Get(keyword, member)
+		n++
+	}
+
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// Cohesion computes the mean similarity among all pairs of keywords in a
+// cluster
+func Cohesion(sim Similarity, cluster []string) float64 {
+	var sum float64
+	var n int
+	for i, a := range cluster {
+		for _, b := range cluster[i+1:] {
+			sum += sim.Get(a, b)
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// assignSecondary finds, for each cluster, the keywords outside of it whose
+// affinity meets the threshold and records them as secondary members
+func assignSecondary(clusters []ClusterGroup, sim Similarity, threshold float64) {
+	primary := make(map[string]string)
+	for _, cluster := range clusters {
+		for _, kw := range cluster.Keywords {
+			if _, ok := primary[kw]; !ok {
+				primary[kw] = cluster.Name
+			}
+		}
+	}
+
+	for i, cluster := range clusters {
+		members := make(map[string]bool)
+		for _, kw := range cluster.Keywords {
+			members[kw] = true
+		}
+
+		var secondary []Membership
+		for kw := range sim {
+			if members[kw] {
+				continue
+			}
+			affinity := Affinity(sim, kw, cluster.Keywords)
+			if affinity < threshold {
+				continue
+			}
+			secondary = append(secondary, Membership{
+				Keyword:  kw,
+				Primary:  primary[kw],
+				Affinity: affinity,
+			})
+		}
+
+		sort.Slice(secondary, func(a, b int) bool {
+			if secondary[a].Affinity != secondary[b].Affinity {
+				return secondary[a].Affinity > secondary[b].Affinity
+			}
+			return secondary[a].Keyword < secondary[b].Keyword
+		})
+		clusters[i].Secondary = secondary
+	}
+}
@@ -0,0 +1,107 @@
+package graph
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestAffinity(t *testing.T) {
+	sim := NewSimilarity()
+	sim.Set("a", "b", 0.8)
+	sim.Set("a", "c", 0.4)
+	sim.Set("b", "c", 0.6)
+
+	tt := []struct {
+		name     string
+		keyword  string
+		cluster  []string
+		expected float64
+	}{
+		{
+			name:     "outside the cluster",
+			keyword:  "a",
+			cluster:  []string{"b", "c"},
+			expected: 0.6,
+		},
+		{
+			name:     "inside the cluster",
+			keyword:  "a",
+			cluster:  []string{"a", "b"},
+			expected: 0.8,
+		},
+		{
+			name:     "only member",
+			keyword:  "a",
+			cluster:  []string{"a"},
+			expected: 0,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Affinity(sim, tc.keyword, tc.cluster); math.Abs(got-tc.expected) > 1e-9 {
+				t.Errorf("expected %f, got %f", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestAssignSecondary(t *testing.T) {
+	sim := NewSimilarity()
+	sim.Set("a", "b", 0.9)
+	sim.Set("c", "d", 0.9)
+	sim.Set("b", "c", 0.7)
+	sim.Set("b", "d", 0.5)
+	sim.Set("a", "c", 0.1)
+	sim.Set("a", "d", 0.1)
+
+	clusters := []ClusterGroup{
+		{Name: "a", Keywords: []string{"a", "b"}},
+		{Name: "c", Keywords: []string{"c", "d"}},
+	}
+	assignSecondary(clusters, sim, 0.5)
+
+	if l := len(clusters[0].Secondary); l != 0 {
+		t.Errorf("expected no secondary members for 'a', got %d", l)
+	}
+	if l := len(clusters[1].Secondary); l != 1 {
+		t.Fatalf("expected 1 secondary member for 'c', got %d", l)
+	}
+	m := clusters[1].Secondary[0]
+	if m.Keyword != "b" || m.Primary != "a" {
+		t.Errorf("expected 'b' from 'a', got '%s' from '%s'", m.Keyword, m.Primary)
+	}
+	if math.Abs(m.Affinity-0.6) > 1e-9 {
+		t.Errorf("expected affinity 0.6, got %f", m.Affinity)
+	}
+
+	o := New().NewOutput(clusters)
+	o.AssignIDs(nil)
+	m = o.Clusters[1].Secondary[0]
+	if m.PrimaryID != o.Clusters[0].ID {
+		t.Errorf("expected primary ID %s, got '%s'", o.Clusters[0].ID, m.PrimaryID)
+	}
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	expected := `{"keyword":"b","primary":"a","primary_id":"` + m.PrimaryID + `","affinity":0.6}`
+	if string(encoded) != expected {
+		t.Errorf("expected %s, got %s", expected, encoded)
+	}
+}
+
+func TestCohesion(t *testing.T) {
+	sim := NewSimilarity()
+	sim.Set("a", "b", 0.8)
+	sim.Set("a", "c", 0.4)
+	sim.Set("b", "c", 0.6)
+
+	if got := Cohesion(sim, []string{"a", "b", "c"}); math.Abs(got-0.6) > 1e-9 {
+		t.Errorf("expected 0.6, got %f", got)
+	}
+	if got := Cohesion(sim, []string{"a"}); got != 0 {
+		t.Errorf("expected 0 for a single keyword, got %f", got)
+	}
+}
@@ -0,0 +1,182 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Parameters records the configuration used to compute clusters
+type Parameters struct {
+	RBOPValue            float64 `json:"rbo_p"`
+	ClusterPower         int     `json:"cluster_power"`
+	ClusterInflation     int     `json:"cluster_inflation"`
+	MaxComputeIterations int     `json:"max_iterations"`
+	SecondaryThreshold   float64 `json:"secondary_threshold"`
+	AssignThreshold      float64 `json:"assign_threshold,omitempty"`
+	// Candidates describes how pairs to compare were chosen, or is empty if
+	// every pair was compared
+	Candidates string `json:"candidates,omitempty"`
+	// Weighting describes how domains were weighted or left out, or is empty
+	// if every domain counted the same
+	Weighting string `json:"weighting,omitempty"`
+}
+
+// Parameters reports the configuration of the graph
+func (g Graph) Parameters() Parameters {
+	return Parameters{
+		RBOPValue:            g.rboPValue,
+		ClusterPower:         g.clusterPower,
+		ClusterInflation:     g.clusterInflation,
+		MaxComputeIterations: g.maxComputeIterations,
+		SecondaryThreshold:   g.secondaryThreshold,
+		AssignThreshold:      g.assignThreshold,
+		Candidates:           g.candidatesName(),
+		Weighting:            g.weightingName(),
+	}
+}
+
+// candidatesName describes the candidate generator, if any
+func (g Graph) candidatesName() string {
+	if g.candidates == nil {
+		return ""
+	}
+	return g.candidates.String()
+}
+
+// weightingName describes how domains are weighted and which are stopped, if
+// any
+func (g Graph) weightingName() string {
+	var parts []string
+	if g.idf {
+		parts = append(parts, "idf")
+	}
+	if len(g.stoplist) > 0 {
+		parts = append(parts, "stoplist="+strings.Join(g.stoplist, ","))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Output is the saved result of a clustering run, used to carry cluster
+// identities from one run to the next
+type Output struct {
+	Created    time.Time  `json:"created"`
+	Parameters Parameters `json:"parameters"`
+	// NextID is the number of the next cluster ID to hand out, so IDs of
+	// dissolved clusters are never reused
+	NextID   int            `json:"next_id"`
+	Clusters []ClusterGroup `json:"clusters"`
+}
+
+// NewOutput creates an Output for clusters computed by the graph
+func (g Graph) NewOutput(clusters []ClusterGroup) *Output {
+	return &Output{
+		Created:    time.Now().UTC(),
+		Parameters: g.Parameters(),
+		NextID:     1,
+		Clusters:   clusters,
+	}
+}
+
+// Save writes the output as JSON
+func (o Output) Save(w io.Writer) error {
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode output: %v", err)
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("could not write output: %v", err)
+	}
+	return nil
+}
+
+// SaveFile writes the output as JSON to the file at path
+func (o Output) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %v", err)
+	}
+
+	err = o.Save(f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("could not close file: %v", cerr)
+	}
+	return err
+}
+
+// LoadOutputFile reads an Output previously written to the file at path
+func LoadOutputFile(path string) (*Output, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %v", err)
+	}
+	defer f.Close()
+
+	return LoadOutput(f)
+}
+
+// LoadOutput reads an Output previously written by Save
+func LoadOutput(rdr io.Reader) (*Output, error) {
+	data, err := ioutil.ReadAll(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("could not read output: %v", err)
+	}
+
+	var o Output
+	err = json.Unmarshal(data, &o)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse output: %v", err)
+	}
+
+	return &o, nil
+}
+
+// WriteText prints clusters in a human-readable form
+func WriteText(w io.Writer, clusters []ClusterGroup) {
+	for _, cluster := range clusters {
+		stats := fmt.Sprintf("cohesion %.2f", cluster.Cohesion)
+		if cluster.Volatility != nil {
+			stats += fmt.Sprintf(", volatility %.2f", *cluster.Volatility)
+		}
+		if cluster.ID != "" {
+			fmt.Fprintf(w, "Cluster %s: '%s' (%s)\n", cluster.ID, cluster.Name, stats)
+		} else {
+			fmt.Fprintf(w, "Cluster: '%s' (%s)\n", cluster.Name, stats)
+		}
+		for _, kw := range cluster.Keywords {
+			fmt.Fprintf(w, "\t%s\n", kw)
+		}
+		for _, m := range cluster.Secondary {
+			primary := fmt.Sprintf("'%s'", m.Primary)
+			if m.PrimaryID != "" {
+				primary = m.PrimaryID + " " + primary
+			}
+			fmt.Fprintf(w, "\t~ %s (affinity %.2f, primary %s)\n", m.Keyword, m.Affinity, primary)
+		}
+	}
+}
+
+// WriteText prints the mapping in a human-readable form
+func (m Mapping) WriteText(w io.Writer) {
+	for _, c := range m.Continued {
+		fmt.Fprintf(w, "continued: %s (jaccard %.2f)\n", c.ID, c.Jaccard)
+	}
+	for _, merge := range m.Merged {
+		fmt.Fprintf(w, "merged: %s <- %s\n", merge.ID, strings.Join(merge.From, ", "))
+	}
+	for _, split := range m.Split {
+		fmt.Fprintf(w, "split: %s -> %s\n", split.ID, strings.Join(split.Into, ", "))
+	}
+	if len(m.New) > 0 {
+		fmt.Fprintf(w, "new: %s\n", strings.Join(m.New, ", "))
+	}
+	if len(m.Dissolved) > 0 {
+		fmt.Fprintf(w, "dissolved: %s\n", strings.Join(m.Dissolved, ", "))
+	}
+}
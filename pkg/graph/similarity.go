@@ -0,0 +1,310 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/candidates"
+	"github.com/thedahv/keyword-cluster-finder/pkg/codec"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rbo"
+)
+
+// Similarity holds the RBO score between pairs of keywords. Scores are
+// symmetric, so setting a->b also sets b->a
+type Similarity map[string]map[string]float64
+
+// NewSimilarity creates a new, empty Similarity instance
+func NewSimilarity() Similarity {
+	return make(map[string]map[string]float64)
+}
+
+// Set records the score between keywords a and b
+func (s Similarity) Set(a, b string, score float64) {
+	if s[a] == nil {
+		s[a] = make(map[string]float64)
+	}
+	if s[b] == nil {
+		s[b] = make(map[string]float64)
+	}
+	s[a][b] = score
+	s[b][a] = score
+}
+
+// Keywords lists the keywords in the matrix in alphabetical order
+func (s Similarity) Keywords() []string {
+	keywords := make([]string, 0, len(s))
+	for keyword := range s {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	return keywords
+}
+
+// Get returns the score between keywords a and b, or 0 if none was recorded
+func (s Similarity) Get(a, b string) float64 {
+	return s[a][b]
+}
+
+// Components groups the keywords of the matrix into connected components, where
+// keywords are connected by a non-zero similarity. Keywords are sorted within
+// each component, and components are ordered by their first keyword
+func (s Similarity) Components() [][]string {
+	seen := make(map[string]bool, len(s))
+	var groups [][]string
+	for _, start := range s.Keywords() {
+		if seen[start] {
+			continue
+		}
+
+		seen[start] = true
+		group := []string{start}
+		for next := 0; next < len(group); next++ {
+			for other, score := range s[group[next]] {
+				if seen[other] || score == 0 {
+					continue
+				}
+				seen[other] = true
+				group = append(group, other)
+			}
+		}
+		sort.Strings(group)
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// ComputeSimilarity calculates the RBO score among all pairs of SERPs in the
+// keyword data, or only the pairs chosen by the candidate generator if the
+// graph has one. Every keyword has a row, even one with no pairs. Progress is
+// reported a keyword's pairs at a time, or a pair at a time for candidates
+func (g Graph) ComputeSimilarity(kd rankings.KeywordData) (Similarity, error) {
+	sim := NewSimilarity()
+	keywords := kd.Keywords()
+	for _, keyword := range keywords {
+		sim[keyword] = make(map[string]float64)
+	}
+	s := g.newScorer(kd)
+	if g.candidates != nil {
+		return sim, g.computePairs(sim, s, g.candidates.Candidates(s.kd))
+	}
+
+	obs := progress.OrNoop(g.progress)
+	obs.Start(progress.Similarity, len(keywords)*(len(keywords)-1)/2)
+	defer obs.Finish(progress.Similarity)
+
+	for i, fromKeyword := range keywords {
+		for _, toKeyword := range keywords[i+1:] {
+			score, err := s.score(fromKeyword, toKeyword)
+			if err != nil {
+				return nil, fmt.Errorf("error computing %s->%s: %v", fromKeyword, toKeyword, err)
+			}
+			sim.Set(fromKeyword, toKeyword, score)
+		}
+		obs.Advance(progress.Similarity, len(keywords)-i-1)
+	}
+
+	return sim, nil
+}
+
+// computePairs calculates the score of each pair into sim. Progress is
+// reported a pair at a time
+func (g Graph) computePairs(sim Similarity, s scorer, pairs []candidates.Pair) error {
+	obs := progress.OrNoop(g.progress)
+	obs.Start(progress.Similarity, len(pairs))
+	defer obs.Finish(progress.Similarity)
+
+	for _, pair := range pairs {
+		score, err := s.score(pair.A, pair.B)
+		if err != nil {
+			return fmt.Errorf("error computing %s->%s: %v", pair.A, pair.B, err)
+		}
+		sim.Set(pair.A, pair.B, score)
+		obs.Advance(progress.Similarity, 1)
+	}
+	return nil
+}
+
+// scorer computes the RBO of pairs of keywords, with the graph's stoplist
+// applied to their SERPs and domains weighted by IDF if configured
+type scorer struct {
+	kd      rankings.KeywordData
+	p       float64
+	weights rbo.Weights
+}
+
+// newScorer prepares a scorer for the keywords in kd. IDF weights are computed
+// once, across every SERP left after the stoplist
+func (g Graph) newScorer(kd rankings.KeywordData) scorer {
+	s := scorer{kd: kd, p: g.rboPValue}
+	if len(g.stoplist) > 0 {
+		stop := rbo.NewStoplist(g.stoplist...)
+		s.kd = make(rankings.KeywordData, len(kd))
+		for keyword, serp := range kd {
+			s.kd[keyword] = stop.Filter(serp)
+		}
+	}
+	if g.idf {
+		s.weights = rbo.IDF(s.kd)
+	}
+	return s
+}
+
+// score computes the RBO of two keywords' SERPs
+func (s scorer) score(a, b string) (float64, error) {
+	if s.weights != nil {
+		return rbo.WeightedRBO(s.kd[a], s.kd[b], s.p, s.weights)
+	}
+	_, _, ext, err := rbo.RBO(s.kd[a], s.kd[b], s.p)
+	return ext, err
+}
+
+// SimilarityMagic starts every similarity file written by Similarity.Save
+const SimilarityMagic = "KCFS"
+
+// similarityVersion is the version of the format written by Similarity.Save
+const similarityVersion = 1
+
+// SimilarityHeader describes how a saved similarity matrix was computed, so it
+// is only reused with the same data and parameters
+type SimilarityHeader struct {
+	RBOPValue float64 `json:"rbo_p"`
+	// Candidates describes how pairs were chosen, or is empty if every pair
+	// was computed
+	Candidates string `json:"candidates,omitempty"`
+	// Weighting describes how domains were weighted or left out, or is empty
+	// if every domain counted the same
+	Weighting string `json:"weighting,omitempty"`
+	Keywords  int    `json:"keywords"`
+	Pairs     int    `json:"pairs"`
+	// DataChecksum is the checksum of the keyword data the matrix was computed
+	// from
+	DataChecksum string `json:"data_checksum"`
+	// Incremental is set when the matrix was brought up to date by
+	// UpdateSimilarity rather than computed from DataChecksum's data, so the
+	// scores of pairs already in it may be stale
+	Incremental bool `json:"incremental,omitempty"`
+}
+
+// SimilarityHeader describes a matrix computed by the graph from kd
+func (g Graph) SimilarityHeader(kd rankings.KeywordData) SimilarityHeader {
+	return SimilarityHeader{
+		RBOPValue:    g.rboPValue,
+		Candidates:   g.candidatesName(),
+		Weighting:    g.weightingName(),
+		DataChecksum: kd.Checksum(),
+	}
+}
+
+// Check reports whether a matrix described by the header can stand in for one
+// the graph would compute from kd. A matrix updated incrementally never can
+func (h SimilarityHeader) Check(g Graph, kd rankings.KeywordData) error {
+	if err := h.CheckParameters(g); err != nil {
+		return err
+	}
+	if h.Incremental {
+		return fmt.Errorf("matrix was updated incrementally, so some scores may not match the keyword data")
+	}
+	if h.DataChecksum != kd.Checksum() {
+		return fmt.Errorf("matrix was computed from different keyword data")
+	}
+	return nil
+}
+
+// CheckParameters reports whether a matrix described by the header was
+// computed the way the graph would compute one, whatever the data
+func (h SimilarityHeader) CheckParameters(g Graph) error {
+	if h.RBOPValue != g.rboPValue {
+		return fmt.Errorf("matrix was computed with RBO p %v, not %v", h.RBOPValue, g.rboPValue)
+	}
+	if h.Candidates != g.candidatesName() {
+		return fmt.Errorf("matrix was computed with candidates '%s', not '%s'", h.Candidates, g.candidatesName())
+	}
+	if h.Weighting != g.weightingName() {
+		return fmt.Errorf("matrix was computed with weighting '%s', not '%s'", h.Weighting, g.weightingName())
+	}
+	return nil
+}
+
+// Save writes the matrix in a compact binary format read by LoadSimilarity,
+// with the header describing how it was computed. The keyword and pair counts
+// of the header are filled in from the matrix. Keywords are written once and
+// referred to by index, and each pair is written once with its exact score
+func (s Similarity) Save(w io.Writer, header SimilarityHeader) error {
+	keywords := s.Keywords()
+	index := make(map[string]int, len(keywords))
+	for i, keyword := range keywords {
+		index[keyword] = i
+	}
+
+	// Each row holds the pairs with keywords after it, by ascending index
+	rows := make([][]int, len(keywords))
+	header.Keywords = len(keywords)
+	header.Pairs = 0
+	for i, keyword := range keywords {
+		for other := range s[keyword] {
+			if j, ok := index[other]; ok && j > i {
+				rows[i] = append(rows[i], j)
+			}
+		}
+		sort.Ints(rows[i])
+		header.Pairs += len(rows[i])
+	}
+
+	cw := codec.NewWriter(w, SimilarityMagic, similarityVersion, header)
+	codec.NewStrings(keywords).Write(cw)
+	for i, row := range rows {
+		cw.Uvarint(uint64(len(row)))
+		last := i
+		for _, j := range row {
+			cw.Uvarint(uint64(j - last))
+			cw.Float64(s[keywords[i]][keywords[j]])
+			last = j
+		}
+	}
+
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("could not write similarity: %v", err)
+	}
+	return nil
+}
+
+// LoadSimilarity reads a matrix written by Similarity.Save, checking it was
+// not corrupted, along with its header
+func LoadSimilarity(rdr io.Reader) (Similarity, SimilarityHeader, error) {
+	var header SimilarityHeader
+	cr, err := codec.NewReader(rdr, SimilarityMagic, similarityVersion, &header)
+	if err != nil {
+		return nil, header, err
+	}
+
+	keywords := codec.ReadStrings(cr)
+	sim := NewSimilarity()
+	for _, keyword := range keywords {
+		sim[keyword] = make(map[string]float64)
+	}
+	for i := 0; i < len(keywords) && cr.Err() == nil; i++ {
+		n := int(cr.Uvarint())
+		j := i
+		for k := 0; k < n && cr.Err() == nil; k++ {
+			j += int(cr.Uvarint())
+			score := cr.Float64()
+			if cr.Err() != nil {
+				break
+			}
+			if j <= i || j >= len(keywords) {
+				return nil, header, fmt.Errorf("pair %d of %s is out of range", k, keywords[i])
+			}
+			sim.Set(keywords[i], keywords[j], score)
+		}
+	}
+
+	if err := cr.Close(); err != nil {
+		return nil, header, err
+	}
+	return sim, header, nil
+}
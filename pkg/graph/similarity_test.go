@@ -0,0 +1,192 @@
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/candidates"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// similarityFixture builds keyword data with two groups of keywords sharing
+// most of their competitors, and one keyword sharing none
+func similarityFixture() rankings.KeywordData {
+	kd := rankings.New()
+	add := func(keyword string, domains ...string) {
+		serp := rankings.SERP{Keyword: keyword}
+		for i, d := range domains {
+			serp.Members = append(serp.Members, rankings.SERPMember{Keyword: keyword, Prominence: i + 1, Domain: d})
+		}
+		kd[keyword] = serp
+	}
+	for i := 0; i < 4; i++ {
+		add(fmt.Sprintf("parking %d", i), "a.com", "b.com", "c.com", fmt.Sprintf("p%d.com", i))
+		add(fmt.Sprintf("condo %d", i), "x.com", "y.com", "z.com", fmt.Sprintf("c%d.com", i))
+	}
+	add("unrelated", "q.com")
+	return kd
+}
+
+func TestSaveLoadSimilarity(t *testing.T) {
+	kd := similarityFixture()
+	g := New(WithClusterInflation(2))
+	sim, err := g.ComputeSimilarity(kd)
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := sim.Save(&buf, g.SimilarityHeader(kd)); err != nil {
+		t.Fatalf("could not save: %v", err)
+	}
+	read, header, err := LoadSimilarity(&buf)
+	if err != nil {
+		t.Fatalf("could not load: %v", err)
+	}
+	if !reflect.DeepEqual(read, sim) {
+		t.Errorf("expected the loaded matrix to equal the saved one")
+	}
+	if header.Keywords != len(kd) || header.Pairs != len(kd)*(len(kd)-1)/2 {
+		t.Errorf("unexpected counts in header %+v", header)
+	}
+
+	if err := header.Check(*g, kd); err != nil {
+		t.Errorf("expected the matrix to match its data, got %v", err)
+	}
+	if err := header.Check(*New(WithRBOPValue(0.5)), kd); err == nil {
+		t.Errorf("expected a different RBO p to be rejected")
+	}
+	delete(kd, "unrelated")
+	if err := header.Check(*g, kd); err == nil {
+		t.Errorf("expected different keyword data to be rejected")
+	}
+
+	expected, err := g.ClusterSimilarity(sim)
+	if err != nil {
+		t.Fatalf("could not cluster: %v", err)
+	}
+	if len(expected) == 0 {
+		t.Fatalf("expected the fixture to form clusters")
+	}
+	clusters, err := g.ClusterSimilarity(read)
+	if err != nil {
+		t.Fatalf("could not cluster: %v", err)
+	}
+	if !reflect.DeepEqual(clusters, expected) {
+		t.Errorf("expected the same clusters from the loaded matrix, got %v and %v", clusters, expected)
+	}
+}
+
+// groupPairs is a candidate generator pairing keywords that share their first
+// word, standing in for one that leaves the graph disconnected
+type groupPairs struct{}
+
+func (groupPairs) Candidates(kd rankings.KeywordData) []candidates.Pair {
+	var pairs []candidates.Pair
+	keywords := kd.Keywords()
+	for i, a := range keywords {
+		for _, b := range keywords[i+1:] {
+			if strings.Fields(a)[0] == strings.Fields(b)[0] {
+				pairs = append(pairs, candidates.NewPair(a, b))
+			}
+		}
+	}
+	return pairs
+}
+
+func (groupPairs) String() string {
+	return "groups"
+}
+
+func TestCandidates(t *testing.T) {
+	kd := similarityFixture()
+	g := New(WithClusterInflation(2), WithCandidates(groupPairs{}))
+
+	sim, err := g.ComputeSimilarity(kd)
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+	if len(sim) != len(kd) {
+		t.Errorf("expected a row for each of %d keywords, got %d", len(kd), len(sim))
+	}
+	if _, ok := sim["parking 0"]["condo 0"]; ok {
+		t.Errorf("expected no score for a pair that is not a candidate")
+	}
+	if len(sim["parking 0"]) != 3 {
+		t.Errorf("expected scores for the other parking keywords, got %v", sim["parking 0"])
+	}
+
+	// Each group is its own component, and both must be clustered
+	if l := len(sim.Components()); l != 3 {
+		t.Errorf("expected 3 components, got %d", l)
+	}
+	clusters, err := g.ClusterSimilarity(sim)
+	if err != nil {
+		t.Fatalf("could not cluster: %v", err)
+	}
+	var names []string
+	for _, c := range clusters {
+		names = append(names, strings.Fields(c.Name)[0])
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "condo,parking" {
+		t.Errorf("expected a condo and a parking cluster, got %v", clusters)
+	}
+
+	if g.Parameters().Candidates != "groups" || g.SimilarityHeader(kd).Candidates != "groups" {
+		t.Errorf("expected the generator to be recorded")
+	}
+}
+
+func TestWeighting(t *testing.T) {
+	// Every SERP leads with the same generic authority, linking the groups
+	kd := similarityFixture()
+	for keyword, serp := range kd {
+		members := []rankings.SERPMember{{Keyword: keyword, Domain: "wiki.org"}}
+		serp.Members = append(members, serp.Members...)
+		kd[keyword] = serp
+	}
+
+	plain, err := New().ComputeSimilarity(kd)
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+	if l := len(plain.Components()); l != 1 {
+		t.Errorf("expected a common domain to link every keyword, got %d components", l)
+	}
+
+	idf, err := New(WithIDF()).ComputeSimilarity(kd)
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+	if idf["parking 0"]["condo 0"] >= plain["parking 0"]["condo 0"] {
+		t.Errorf("expected IDF to weaken the link through the common domain, got %v from %v",
+			idf["parking 0"]["condo 0"], plain["parking 0"]["condo 0"])
+	}
+	if idf["parking 0"]["parking 1"]/idf["parking 0"]["condo 0"] <= plain["parking 0"]["parking 1"]/plain["parking 0"]["condo 0"] {
+		t.Errorf("expected IDF to widen the gap between related and unrelated keywords")
+	}
+
+	g := New(WithIDF(), WithStoplist("wiki.org"))
+	stopped, err := g.ComputeSimilarity(kd)
+	if err != nil {
+		t.Fatalf("could not compute similarity: %v", err)
+	}
+	if l := len(stopped.Components()); l != 3 {
+		t.Errorf("expected the stoplist to separate the groups, got %d components", l)
+	}
+
+	if w := g.Parameters().Weighting; w != "idf, stoplist=wiki.org" {
+		t.Errorf("unexpected weighting '%s'", w)
+	}
+	if err := g.SimilarityHeader(kd).Check(*New(WithIDF()), kd); err == nil {
+		t.Errorf("expected a matrix with different weighting to be rejected")
+	}
+	if err := g.SimilarityHeader(kd).CheckParameters(*New(WithStoplist("wiki.org"), WithIDF())); err != nil {
+		t.Errorf("expected the same weighting to be accepted, got %v", err)
+	}
+}
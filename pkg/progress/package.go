@@ -0,0 +1,4 @@
+// Package progress reports how far along the long-running stages of the
+// clustering pipeline are, without tying the library to one way of showing
+// it.
+package progress
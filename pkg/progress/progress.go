@@ -0,0 +1,185 @@
+package progress
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+)
+
+// Stage is a step of the clustering pipeline
+type Stage string
+
+const (
+	// Fetch loads SERPs from disk, a database or a cache, counting keywords
+	// or files
+	Fetch Stage = "fetch"
+	// Similarity computes RBO between pairs of keywords, counting pairs
+	Similarity Stage = "similarity"
+	// Clustering runs Markov clustering over the similarity graph
+	Clustering Stage = "clustering"
+	// Output names and scores the clusters found and assigns secondary
+	// members, counting clusters
+	Output Stage = "output"
+)
+
+// Observer is told as stages start, advance and finish. A stage's total is the
+// number of units of work it expects, or 0 if unknown. Advance may be called
+// from several goroutines at once, so observers must be safe for concurrent
+// use
+type Observer interface {
+	Start(stage Stage, total int)
+	Advance(stage Stage, n int)
+	Finish(stage Stage)
+}
+
+// New creates the observer named kind: "bar" for terminal progress bars, "log"
+// for log lines, or "none"
+func New(kind string) (Observer, error) {
+	switch kind {
+	case "bar":
+		return NewBar(), nil
+	case "log":
+		return NewLog(log.New(log.Writer(), "", log.LstdFlags)), nil
+	case "none", "":
+		return Noop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown progress reporter '%s'", kind)
+	}
+}
+
+// OrNoop returns o, or an observer that does nothing if o is nil
+func OrNoop(o Observer) Observer {
+	if o == nil {
+		return Noop{}
+	}
+	return o
+}
+
+// Noop ignores progress
+type Noop struct{}
+
+// Start does nothing
+func (Noop) Start(Stage, int) {}
+
+// Advance does nothing
+func (Noop) Advance(Stage, int) {}
+
+// Finish does nothing
+func (Noop) Finish(Stage) {}
+
+// Bar shows a terminal progress bar for each stage
+type Bar struct {
+	lock sync.Mutex
+	bars map[Stage]*pb.ProgressBar
+}
+
+// NewBar creates a Bar
+func NewBar() *Bar {
+	return &Bar{bars: make(map[Stage]*pb.ProgressBar)}
+}
+
+// Start starts a progress bar for the stage
+func (b *Bar) Start(stage Stage, total int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	bar := pb.New(total)
+	bar.Set("prefix", fmt.Sprintf("%-10s ", stage))
+	b.bars[stage] = bar.Start()
+}
+
+// Advance moves the stage's progress bar along
+func (b *Bar) Advance(stage Stage, n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if bar, ok := b.bars[stage]; ok {
+		bar.Add(n)
+	}
+}
+
+// Finish completes the stage's progress bar
+func (b *Bar) Finish(stage Stage) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if bar, ok := b.bars[stage]; ok {
+		bar.Finish()
+		delete(b.bars, stage)
+	}
+}
+
+// Log writes a structured log line as each stage starts and finishes, and
+// every time it gets another tenth of the way through
+type Log struct {
+	logger *log.Logger
+	now    func() time.Time
+
+	lock   sync.Mutex
+	stages map[Stage]*logStage
+}
+
+type logStage struct {
+	started time.Time
+	total   int
+	done    int
+	// logged is the number of tenths of the total last logged
+	logged int
+}
+
+// NewLog creates a Log writing to logger
+func NewLog(logger *log.Logger) *Log {
+	return &Log{
+		logger: logger,
+		now:    time.Now,
+		stages: make(map[Stage]*logStage),
+	}
+}
+
+// Start logs the start of the stage
+func (l *Log) Start(stage Stage, total int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.stages[stage] = &logStage{started: l.now(), total: total}
+	l.logger.Printf("stage=%s event=start total=%d", stage, total)
+}
+
+// Advance logs progress through the stage each time another tenth of it is
+// done
+func (l *Log) Advance(stage Stage, n int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	s, ok := l.stages[stage]
+	if !ok {
+		return
+	}
+	s.done += n
+	if s.total <= 0 {
+		return
+	}
+
+	if tenths := s.done * 10 / s.total; tenths > s.logged && s.done < s.total {
+		s.logged = tenths
+		l.logger.Printf("stage=%s event=progress done=%d total=%d percent=%d",
+			stage, s.done, s.total, s.done*100/s.total)
+	}
+}
+
+// Finish logs the end of the stage and how long it took
+func (l *Log) Finish(stage Stage) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	s, ok := l.stages[stage]
+	if !ok {
+		return
+	}
+	delete(l.stages, stage)
+	l.logger.Printf("stage=%s event=finish done=%d elapsed=%s",
+		stage, s.done, l.now().Sub(s.started).Round(time.Millisecond))
+}
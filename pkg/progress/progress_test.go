@@ -0,0 +1,53 @@
+package progress
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLog(log.New(&buf, "", 0))
+	start := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return start }
+
+	l.Start(Similarity, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Advance(Similarity, 1)
+		}()
+	}
+	wg.Wait()
+	l.now = func() time.Time { return start.Add(1500 * time.Millisecond) }
+	l.Finish(Similarity)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if first := lines[0]; first != "stage=similarity event=start total=100" {
+		t.Errorf("unexpected start line %s", first)
+	}
+	if last := lines[len(lines)-1]; last != "stage=similarity event=finish done=100 elapsed=1.5s" {
+		t.Errorf("unexpected finish line %s", last)
+	}
+	// One line for each tenth but the last, which is reported by finish
+	if l := len(lines); l != 11 {
+		t.Errorf("expected 11 lines, got %d:\n%s", l, buf.String())
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, kind := range []string{"bar", "log", "none"} {
+		if _, err := New(kind); err != nil {
+			t.Errorf("expected %s to be valid, got %v", kind, err)
+		}
+	}
+	if _, err := New("spinner"); err == nil {
+		t.Errorf("expected an error for an unknown reporter")
+	}
+}
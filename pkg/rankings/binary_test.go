@@ -0,0 +1,47 @@
+package rankings
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	kd := exportFixture()
+
+	var buf bytes.Buffer
+	if err := kd.Save(&buf); err != nil {
+		t.Fatalf("could not save: %v", err)
+	}
+	if !IsBinary(bufio.NewReader(bytes.NewReader(buf.Bytes()))) {
+		t.Errorf("expected saved data to be recognized as binary")
+	}
+
+	read, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("could not load: %v", err)
+	}
+	if !reflect.DeepEqual(read, kd) {
+		t.Errorf("expected %v, got %v", kd, read)
+	}
+
+	if read.Checksum() != kd.Checksum() {
+		t.Errorf("expected equal data to have equal checksums")
+	}
+	delete(read, "no results")
+	if read.Checksum() == kd.Checksum() {
+		t.Errorf("expected different data to have different checksums")
+	}
+
+	var bundle bytes.Buffer
+	if err := kd.WriteBundle(&bundle); err != nil {
+		t.Fatalf("could not write bundle: %v", err)
+	}
+	if IsBinary(bufio.NewReader(&bundle)) {
+		t.Errorf("expected a JSON bundle not to be recognized as binary")
+	}
+	if _, err := Load(&bundle); err == nil {
+		t.Errorf("expected loading a JSON bundle to fail")
+	}
+}
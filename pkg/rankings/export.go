@@ -0,0 +1,215 @@
+package rankings
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+)
+
+// ManifestFile is the name of the manifest written alongside exported SERP
+// files. ProcessDirectory skips it
+const ManifestFile = "manifest.json"
+
+// Manifest describes how exported SERP data was obtained, so others can
+// reproduce clusters from it
+type Manifest struct {
+	DomainID  int               `json:"domain_id"`
+	FetchedAt time.Time         `json:"fetched_at"`
+	Query     data.QueryOptions `json:"query"`
+	// Keywords maps each exported keyword to the file holding its SERP, which
+	// for a bundle is the bundle itself
+	Keywords map[string]string `json:"keywords,omitempty"`
+	// Empty lists keywords that had no rankings. They are not exported, since
+	// an empty SERP has no members to say which keyword it belongs to
+	Empty []string `json:"empty,omitempty"`
+	// Failed lists keywords that could not be fetched
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Write encodes the SERP in the JSON format read by Parse
+func (s SERP) Write(w io.Writer) error {
+	members := s.Members
+	if members == nil {
+		members = []SERPMember{}
+	}
+
+	data, err := json.MarshalIndent(members, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode SERP: %v", err)
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("could not write SERP: %v", err)
+	}
+	return nil
+}
+
+// WriteBundle encodes all SERPs as a single JSON array of SERP members, in
+// keyword order. ParseBundle reads it back
+func (kd KeywordData) WriteBundle(w io.Writer) error {
+	members := []SERPMember{}
+	for _, keyword := range kd.Keywords() {
+		members = append(members, kd[keyword].Members...)
+	}
+
+	data, err := json.MarshalIndent(members, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode bundle: %v", err)
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("could not write bundle: %v", err)
+	}
+	return nil
+}
+
+// WriteBundleFile writes all SERPs as a bundle to the file at p, as by
+// WriteBundle, along with the manifest at BundleManifestPath(p). Keywords
+// without rankings leave nothing in the bundle, so they are listed in the
+// manifest instead
+func (kd KeywordData) WriteBundleFile(p string, manifest Manifest) error {
+	err := writeFile(p, kd.WriteBundle)
+	if err != nil {
+		return err
+	}
+
+	manifest.Keywords = make(map[string]string)
+	manifest.Empty = nil
+	for _, keyword := range kd.Keywords() {
+		if kd[keyword].Length() == 0 {
+			manifest.Empty = append(manifest.Empty, keyword)
+			continue
+		}
+		manifest.Keywords[keyword] = filepath.Base(p)
+	}
+
+	return WriteManifest(BundleManifestPath(p), manifest)
+}
+
+// BundleManifestPath names the manifest written alongside the bundle at p,
+// such as "serps.manifest.json" for "serps.json"
+func BundleManifestPath(p string) string {
+	return strings.TrimSuffix(p, filepath.Ext(p)) + ".manifest.json"
+}
+
+// WriteDirectory writes each SERP to its own file in directory, in the layout
+// read by ProcessDirectory, along with the manifest. Files are named after
+// their keyword. Keywords without rankings are listed in the manifest instead
+func (kd KeywordData) WriteDirectory(directory string, manifest Manifest) error {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+
+	manifest.Keywords = make(map[string]string)
+	manifest.Empty = nil
+	used := make(map[string]bool)
+	for _, keyword := range kd.Keywords() {
+		serp := kd[keyword]
+		if serp.Length() == 0 {
+			manifest.Empty = append(manifest.Empty, keyword)
+			continue
+		}
+
+		name := FileName(keyword)
+		if used[name] || name == ManifestFile {
+			// Keywords differing only in punctuation or case would share a
+			// name, so disambiguate with a hash of the keyword
+			sum := sha256.Sum256([]byte(keyword))
+			name = strings.TrimSuffix(name, ".json") + "-" + hex.EncodeToString(sum[:4]) + ".json"
+		}
+		used[name] = true
+		manifest.Keywords[keyword] = name
+
+		err := writeFile(path.Join(directory, name), serp.Write)
+		if err != nil {
+			return err
+		}
+	}
+
+	return WriteManifest(path.Join(directory, ManifestFile), manifest)
+}
+
+// WriteManifest writes the manifest as JSON to the file at p
+func WriteManifest(p string, manifest Manifest) error {
+	return writeFile(p, func(w io.Writer) error {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("could not encode manifest: %v", err)
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// ParseBundle builds KeywordData from a bundle of SERP members for many
+// keywords, as written by WriteBundle. The bundle is decoded a SERP at a time,
+// so only the resulting data is held in memory
+func ParseBundle(rdr io.Reader) (KeywordData, error) {
+	kd := New()
+	dec := NewDecoder(rdr)
+	for {
+		next, err := dec.Next()
+		if err == io.EOF {
+			return kd, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		serp := kd[next.Keyword]
+		serp.Keyword = next.Keyword
+		serp.Members = append(serp.Members, next.Members...)
+		kd[next.Keyword] = serp
+	}
+}
+
+// FileName converts a keyword to the name of the file its SERP is stored in,
+// such as "apartment-building-parking.json"
+func FileName(keyword string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(keyword) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	name := strings.TrimSuffix(b.String(), "-")
+	if name == "" {
+		name = "keyword"
+	}
+	return name + ".json"
+}
+
+func writeFile(p string, write func(io.Writer) error) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %v", p, err)
+	}
+
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write %s: %v", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package rankings
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func exportFixture() KeywordData {
+	kd := New()
+	for _, kw := range []string{"apartment parking", "Apartment Parking!", "parking garage"} {
+		kd[kw] = SERP{
+			Keyword: kw,
+			Members: []SERPMember{
+				{Keyword: kw, Prominence: 1, Domain: "a.com"},
+				{Keyword: kw, Prominence: 2, Domain: "b.com"},
+			},
+		}
+	}
+	kd["no results"] = SERP{Keyword: "no results"}
+	return kd
+}
+
+func TestWriteDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rankings")
+	if err != nil {
+		t.Fatalf("could not create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kd := exportFixture()
+	err = kd.WriteDirectory(dir, Manifest{DomainID: 6290, FetchedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	read, err := ProcessDirectory(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	delete(kd, "no results")
+	if !reflect.DeepEqual(read, kd) {
+		t.Errorf("expected %v, got %v", kd, read)
+	}
+
+	raw, err := ioutil.ReadFile(path.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	var m Manifest
+	err = json.Unmarshal(raw, &m)
+	if err != nil {
+		t.Fatalf("could not parse manifest: %v", err)
+	}
+	if m.DomainID != 6290 {
+		t.Errorf("expected domain 6290, got %d", m.DomainID)
+	}
+	if !reflect.DeepEqual(m.Empty, []string{"no results"}) {
+		t.Errorf("expected 'no results' to be listed as empty, got %v", m.Empty)
+	}
+	if len(m.Keywords) != 3 {
+		t.Errorf("expected 3 exported keywords, got %d", len(m.Keywords))
+	}
+	if a, b := m.Keywords["apartment parking"], m.Keywords["Apartment Parking!"]; a == b {
+		t.Errorf("expected colliding keywords in different files, both in %s", a)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	kd := exportFixture()
+	delete(kd, "no results")
+
+	var buf bytes.Buffer
+	err := kd.WriteBundle(&buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	read, err := ParseBundle(&buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(read, kd) {
+		t.Errorf("expected %v, got %v", kd, read)
+	}
+}
+
+func TestWriteBundleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rankings")
+	if err != nil {
+		t.Fatalf("could not create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kd := exportFixture()
+	p := path.Join(dir, "serps.json")
+	err = kd.WriteBundleFile(p, Manifest{DomainID: 6290, FetchedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatalf("could not open bundle: %v", err)
+	}
+	defer f.Close()
+	read, err := ParseBundle(f)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	raw, err := ioutil.ReadFile(path.Join(dir, "serps.manifest.json"))
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	var m Manifest
+	err = json.Unmarshal(raw, &m)
+	if err != nil {
+		t.Fatalf("could not parse manifest: %v", err)
+	}
+
+	// The bundle and manifest together account for every keyword
+	for keyword, file := range m.Keywords {
+		if file != "serps.json" {
+			t.Errorf("expected '%s' in the bundle, got %s", keyword, file)
+		}
+		if _, ok := read[keyword]; !ok {
+			t.Errorf("expected '%s' in the bundle", keyword)
+		}
+	}
+	for _, keyword := range m.Empty {
+		read[keyword] = SERP{Keyword: keyword}
+	}
+	if len(m.Keywords) != 3 || !reflect.DeepEqual(m.Empty, []string{"no results"}) {
+		t.Errorf("expected 3 keywords and 'no results' empty, got %v and %v", m.Keywords, m.Empty)
+	}
+	if !reflect.DeepEqual(read, kd) {
+		t.Errorf("expected %v, got %v", kd, read)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	for keyword, expected := range map[string]string{
+		"apartment building parking": "apartment-building-parking.json",
+		"  Café / Bar's menu ":       "café-bar-s-menu.json",
+		"???":                        "keyword.json",
+	} {
+		if name := FileName(keyword); name != expected {
+			t.Errorf("expected %s for '%s', got %s", expected, keyword, name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package rankings
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
+)
+
+// ErrorPolicy decides what a loader does when some of its inputs fail
+type ErrorPolicy int
+
+const (
+	// Skip leaves failed inputs out, loads the rest, and reports the failures
+	// in a BuildError with Skipped set. This is the default
+	Skip ErrorPolicy = iota
+	// FailFast stops loading at the first failure and adds nothing
+	FailFast
+	// Collect loads every input to report all failures at once, but adds
+	// nothing unless they all loaded
+	Collect
+)
+
+// ParseErrorPolicy reads a policy from its name: skip, fail-fast or collect
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	switch name {
+	case "skip":
+		return Skip, nil
+	case "fail-fast":
+		return FailFast, nil
+	case "collect":
+		return Collect, nil
+	}
+	return Skip, fmt.Errorf("unknown error policy '%s'", name)
+}
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case FailFast:
+		return "fail-fast"
+	case Collect:
+		return "collect"
+	}
+	return "skip"
+}
+
+// loadConfig controls how SERP files are loaded
+type loadConfig struct {
+	concurrency int
+	progress    progress.Observer
+	policy      ErrorPolicy
+	recursive   bool
+	include     []string
+	exclude     []string
+}
+
+// LoadOption configures how the loaders, BuildFromDisk, BuildFromDatabase and
+// ProcessDirectory, load their inputs
+type LoadOption func(*loadConfig)
+
+// WithConcurrency configures the maximum number of files read and parsed at
+// once. Zero, the default, uses the number of CPUs
+func WithConcurrency(n int) LoadOption {
+	return func(c *loadConfig) {
+		c.concurrency = n
+	}
+}
+
+// WithProgress reports files loaded as the fetch stage to obs
+func WithProgress(obs progress.Observer) LoadOption {
+	return func(c *loadConfig) {
+		c.progress = obs
+	}
+}
+
+// WithErrorPolicy configures what to do when some inputs fail to load
+func WithErrorPolicy(p ErrorPolicy) LoadOption {
+	return func(c *loadConfig) {
+		c.policy = p
+	}
+}
+
+// WithRecursive makes ProcessDirectory descend into subdirectories rather
+// than ignoring them
+func WithRecursive() LoadOption {
+	return func(c *loadConfig) {
+		c.recursive = true
+	}
+}
+
+// WithInclude configures the glob patterns, as understood by filepath.Match,
+// that files in a directory must match to be loaded. The default is *.json.
+// Patterns without a slash match file names, and patterns with one match paths
+// relative to the directory
+func WithInclude(patterns ...string) LoadOption {
+	return func(c *loadConfig) {
+		c.include = patterns
+	}
+}
+
+// WithExclude configures glob patterns for files and subdirectories to leave
+// out, matched the same way as WithInclude
+func WithExclude(patterns ...string) LoadOption {
+	return func(c *loadConfig) {
+		c.exclude = patterns
+	}
+}
+
+func newLoadConfig(options []LoadOption) loadConfig {
+	c := loadConfig{
+		progress: progress.Noop{},
+		include:  []string{"*.json"},
+	}
+	for _, opt := range options {
+		opt(&c)
+	}
+	if c.concurrency < 1 {
+		c.concurrency = runtime.NumCPU()
+	}
+	c.progress = progress.OrNoop(c.progress)
+	return c
+}
+
+// forEach calls work for every index in [0, n) using at most concurrency
+// goroutines, returning once all calls are done. work must only write results
+// for its own index. Once any call returns false, no more calls are started,
+// though calls already running finish
+func forEach(n int, concurrency int, work func(i int) bool) {
+	if concurrency > n {
+		concurrency = n
+	}
+
+	indexes := make(chan int)
+	stop := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+				if !work(i) {
+					once.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case <-stop:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,234 @@
+package rankings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
+)
+
+// writeFixtures writes n SERP files to dir, making every tenth one invalid
+// JSON, and returns their paths along with the number of invalid files
+func writeFixtures(t *testing.T, dir string, n int) ([]string, int) {
+	var paths []string
+	var invalid int
+	for i := 0; i < n; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("keyword-%d.json", i))
+		content := fmt.Sprintf(`[
+			{"keyword": "keyword %[1]d", "prominence": 1, "competitor": "a%[1]d.com"},
+			{"keyword": "keyword %[1]d", "prominence": 2, "competitor": "b.com"}
+		]`, i)
+		if i%10 == 0 {
+			content = content[:len(content)/2]
+			invalid++
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write fixture: %v", err)
+		}
+		paths = append(paths, p)
+	}
+
+	// Files without rankings are skipped rather than added under an empty
+	// keyword
+	empty := filepath.Join(dir, "empty.json")
+	if err := ioutil.WriteFile(empty, []byte("[]"), 0644); err != nil {
+		t.Fatalf("could not write fixture: %v", err)
+	}
+	return append(paths, empty, filepath.Join(dir, "missing.json")), invalid + 1
+}
+
+func TestBuildFromDiskConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rankings")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const n = 500
+	paths, failures := writeFixtures(t, dir, n)
+
+	for _, concurrency := range []int{1, 4, 64} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			kd := New()
+			err := kd.BuildFromDisk(paths, WithConcurrency(concurrency))
+
+			var buildErr BuildError
+			if !errors.As(err, &buildErr) {
+				t.Fatalf("expected a BuildError, got %v", err)
+			}
+			if l := len(buildErr.Errors); l != failures {
+				t.Errorf("expected %d errors, got %d", failures, l)
+			}
+			if l := len(kd); l != n-failures+1 {
+				t.Errorf("expected %d keywords, got %d", n-failures+1, l)
+			}
+			for keyword, serp := range kd {
+				if keyword == "" || serp.Length() != 2 {
+					t.Errorf("unexpected SERP for '%s': %v", keyword, serp)
+				}
+			}
+			if _, ok := kd["keyword 10"]; ok {
+				t.Errorf("expected no SERP for an invalid file")
+			}
+		})
+	}
+}
+
+func TestBuildFromDatabaseConcurrently(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not create mock database: %v", err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	const n = 50
+	var keywords []string
+	for i := 0; i < n; i++ {
+		kw := fmt.Sprintf("keyword %d", i)
+		keywords = append(keywords, kw)
+
+		q := mock.ExpectQuery(`name = ANY`).WithArgs(6290, pq.Array([]string{kw}), 20, 20)
+		if i == 7 {
+			q.WillReturnError(errors.New("syntax error"))
+			continue
+		}
+		q.WillReturnRows(sqlmock.NewRows([]string{"keyword", "prominence", "competitor"}).
+			AddRow(kw, 1, "a.com"))
+	}
+
+	d, err := data.New(data.WithDB(db), data.WithBatchSize(1), data.WithMaxInFlight(8))
+	if err != nil {
+		t.Fatalf("could not create driver: %v", err)
+	}
+
+	kd := New()
+	_, err = kd.BuildFromDatabase(context.Background(), d, 6290, keywords, data.DefaultQueryOptions(), nil)
+	var buildErr BuildError
+	if !errors.As(err, &buildErr) || len(buildErr.Errors) != 1 {
+		t.Fatalf("expected a BuildError with one error, got %v", err)
+	}
+	if l := len(kd); l != n-1 {
+		t.Errorf("expected %d keywords, got %d", n-1, l)
+	}
+}
+
+func TestErrorPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rankings")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Every tenth fixture is invalid, so with one worker fail-fast stops at
+	// the very first file
+	paths, failures := writeFixtures(t, dir, 20)
+	missing := paths[len(paths)-1]
+
+	tests := []struct {
+		policy   ErrorPolicy
+		errors   int
+		keywords int
+		skipped  bool
+	}{
+		{Skip, failures, 20 - failures + 1, true},
+		{FailFast, 1, 0, false},
+		{Collect, failures, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			kd := New()
+			err := kd.BuildFromDisk(paths, WithConcurrency(1), WithErrorPolicy(tt.policy))
+
+			var buildErr BuildError
+			if !errors.As(err, &buildErr) {
+				t.Fatalf("expected a BuildError, got %v", err)
+			}
+			if l := len(buildErr.Errors); l != tt.errors {
+				t.Errorf("expected %d errors, got %d", tt.errors, l)
+			}
+			if buildErr.Skipped != tt.skipped {
+				t.Errorf("expected skipped to be %v", tt.skipped)
+			}
+			if l := len(kd); l != tt.keywords {
+				t.Errorf("expected %d keywords, got %d", tt.keywords, l)
+			}
+			if tt.policy != FailFast && !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected the missing file to be found through Unwrap")
+			}
+
+			var inputErr InputError
+			if !errors.As(err, &inputErr) || inputErr.Input != paths[0] {
+				t.Errorf("expected the first error to be for %s, got %v", paths[0], inputErr)
+			}
+			if tt.errors > 1 && !strings.Contains(err.Error(), missing) {
+				t.Errorf("expected every failed file in the error, got %s", err)
+			}
+		})
+	}
+
+	if _, err := ParseErrorPolicy("retry"); err == nil {
+		t.Errorf("expected an unknown policy to be rejected")
+	}
+}
+
+// cancelOnAdvance cancels a context as soon as any progress is made
+type cancelOnAdvance struct {
+	progress.Noop
+	cancel context.CancelFunc
+}
+
+func (c cancelOnAdvance) Advance(progress.Stage, int) {
+	c.cancel()
+}
+
+func TestBuildFromDatabaseCancelled(t *testing.T) {
+	for _, policy := range []ErrorPolicy{Skip, FailFast, Collect} {
+		t.Run(policy.String(), func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("could not create mock database: %v", err)
+			}
+			defer db.Close()
+			mock.MatchExpectationsInOrder(false)
+
+			// The first batch lands and cancels the fetch while the second is
+			// still running, and the third never starts
+			mock.ExpectQuery(`name = ANY`).WithArgs(6290, pq.Array([]string{"a"}), 20, 20).
+				WillReturnRows(sqlmock.NewRows([]string{"keyword", "prominence", "competitor"}).AddRow("a", 1, "a.com"))
+			mock.ExpectQuery(`name = ANY`).WithArgs(6290, pq.Array([]string{"b"}), 20, 20).
+				WillDelayFor(time.Minute).
+				WillReturnRows(sqlmock.NewRows([]string{"keyword", "prominence", "competitor"}).AddRow("b", 1, "b.com"))
+
+			d, err := data.New(data.WithDB(db), data.WithBatchSize(1), data.WithMaxInFlight(2))
+			if err != nil {
+				t.Fatalf("could not create driver: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			kd := New()
+			_, err = kd.BuildFromDatabase(ctx, d, 6290, []string{"a", "b", "c"}, data.DefaultQueryOptions(),
+				cancelOnAdvance{cancel: cancel}, WithErrorPolicy(policy))
+			if _, ok := err.(BuildError); ok || err == nil {
+				t.Fatalf("expected the cancellation, got %v", err)
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected the error to wrap context.Canceled, got %v", err)
+			}
+			if len(kd) != 0 {
+				t.Errorf("expected nothing added from an incomplete fetch, got %d keywords", len(kd))
+			}
+		})
+	}
+}
@@ -1,22 +1,21 @@
 package rankings
 
 import (
-	"database/sql"
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
-	"path"
-	"sync"
+	"sort"
+	"strings"
 
-	"github.com/cheggaaa/pb"
 	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+	"github.com/thedahv/keyword-cluster-finder/pkg/progress"
 )
 
-const maxInFlight = 10
-
 // KeywordData contains all SERP data for a group of keywords
 type KeywordData map[string]SERP
 
@@ -25,6 +24,17 @@ func New() KeywordData {
 	return make(map[string]SERP)
 }
 
+// Keywords lists the keywords in the data in alphabetical order
+func (kd KeywordData) Keywords() []string {
+	keywords := make([]string, 0, len(kd))
+	for keyword := range kd {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	return keywords
+}
+
 // SERP contains a set of related serps
 type SERP struct {
 	Keyword string
@@ -70,142 +80,244 @@ func Parse(rdr io.Reader) (SERP, error) {
 }
 
 // BuildFromDisk builds a KeywordData set by parsing and adding SERP members
-func (kd KeywordData) BuildFromDisk(paths []string) error {
-	var errors []error
+// from each file in paths. Files are loaded by a bounded pool of workers; see
+// WithConcurrency. A file that fails to load adds nothing to kd, and files
+// without rankings are skipped since they cannot say which keyword they
+// belong to. Failed files are handled by the error policy, and returned in a
+// BuildError in the order of paths
+func (kd KeywordData) BuildFromDisk(paths []string, options ...LoadOption) error {
 	if len(paths) == 0 {
 		return nil
 	}
+	conf := newLoadConfig(options)
+	conf.progress.Start(progress.Fetch, len(paths))
+	defer conf.progress.Finish(progress.Fetch)
+
+	// Each worker only writes to its own path's slots, so neither slice needs
+	// a lock
+	serps := make([]SERP, len(paths))
+	errs := make([]error, len(paths))
+	forEach(len(paths), conf.concurrency, func(i int) bool {
+		serps[i], errs[i] = parseFile(paths[i])
+		conf.progress.Advance(progress.Fetch, 1)
+		return errs[i] == nil || conf.policy != FailFast
+	})
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 && conf.policy != Skip {
+		return BuildError{Errors: failed}
+	}
 
-	var lock sync.Mutex
-	var wg sync.WaitGroup
-	wg.Add(len(paths))
-
-	for _, path := range paths {
-		go func(path string) {
-			defer wg.Done()
-			f, err := os.Open(path)
-			if err != nil {
-				errors = append(errors, fmt.Errorf("could not open %s: %v", path, err))
-				return
-			}
-			defer f.Close()
-			serp, err := Parse(f)
-			if err != nil {
-				errors = append(errors, fmt.Errorf("could not parse %s: %v", path, err))
-			}
+	for i, err := range errs {
+		if err == nil && serps[i].Length() > 0 {
+			kd[serps[i].Keyword] = serps[i]
+		}
+	}
+	if len(failed) > 0 {
+		return BuildError{Errors: failed, Skipped: true}
+	}
+	return nil
+}
 
-			lock.Lock()
-			kd[serp.Keyword] = serp
-			lock.Unlock()
-		}(path)
+// parseFile parses the SERP in the file at p
+func parseFile(p string) (SERP, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return SERP{}, InputError{Input: p, Err: fmt.Errorf("could not open file: %w", err)}
 	}
+	defer f.Close()
 
-	wg.Wait()
-	if len(errors) > 0 {
-		return BuildError{Errors: errors}
+	serp, err := Parse(f)
+	if err != nil {
+		return SERP{}, InputError{Input: p, Err: err}
 	}
-	return nil
+	return serp, nil
 }
 
-// BuildFromDatabase fetches prominent SERP members from the database for each
-// given keyword
-func (kd KeywordData) BuildFromDatabase(driver *data.Driver, domainID int, keywords []string, bar *pb.ProgressBar) error {
-	var errors []error
+// BuildFromDatabase fetches prominent SERP members from the database, or any
+// other source, for each given keyword, using the rankings selected by opts.
+// Keywords are fetched in batches, so the fetch stage reported to obs, which
+// may be nil, advances a batch at a time. Cancelling ctx stops queries in
+// flight and returns the cancellation rather than a BuildError, adding nothing
+// to kd. Of the load options, only the error policy applies.
+//
+// Keywords that could not be fetched, even after retries, are left out of kd
+// rather than given an empty SERP, and are handled by the error policy along
+// with the report of attempts made. The source only reports failures once
+// every batch has run, so FailFast adds nothing but cannot stop early
+func (kd KeywordData) BuildFromDatabase(ctx context.Context, source data.Source, domainID int, keywords []string, opts data.QueryOptions, obs progress.Observer, options ...LoadOption) (data.FetchReport, error) {
 	if len(keywords) == 0 {
-		return nil
+		return data.FetchReport{}, nil
 	}
-
-	var lock sync.Mutex
-	var wg sync.WaitGroup
-	wg.Add(len(keywords))
-
-	// If you're not familiar with this pattern, we are using a buffered channel
-	// to limit the amount of concurrent work in-flight. This is an expensive
-	// query on the database, so we want to control the number of concurrent
-	// queries. A buffered channel will block when it is full, so work can only
-	// be added as work is removed.
-	work := make(chan string, maxInFlight)
-	go func() {
-		for keyword := range work {
-			go func(keyword string) {
-				defer wg.Done()
-				var serp SERP
-				serp.Keyword = keyword
-
-				err := driver.FetchSERP(domainID, keyword, func(row *sql.Rows) error {
-					sm := SERPMember{}
-					err := row.Scan(&sm.Keyword, &sm.Prominence, &sm.Domain)
-					if err != nil {
-						return err
-					}
-					serp.Members = append(serp.Members, sm)
-					return nil
-				})
-
-				if err != nil {
-					errors = append(errors, fmt.Errorf("could not query for %s: %v", keyword, err))
-				}
-
-				lock.Lock()
-				kd[keyword] = serp
-				lock.Unlock()
-
-				if bar != nil {
-					bar.Increment()
-				}
-			}(keyword)
+	conf := newLoadConfig(options)
+	obs = progress.OrNoop(obs)
+	obs.Start(progress.Fetch, len(keywords))
+	defer obs.Finish(progress.Fetch)
+
+	// FetchSERPs never calls us concurrently, so fetched needs no lock. SERPs
+	// are only added to kd once the policy says they may be
+	fetched := New()
+	report, err := source.FetchSERPs(ctx, domainID, keywords, opts, func(batch []string, rows []data.SERPRow) error {
+		for _, keyword := range batch {
+			fetched[keyword] = SERP{Keyword: keyword}
 		}
-	}()
+		for _, row := range rows {
+			serp := fetched[row.Keyword]
+			serp.Members = append(serp.Members, SERPMember{
+				Keyword:    row.Keyword,
+				Prominence: row.Prominence,
+				Domain:     row.Domain,
+			})
+			fetched[row.Keyword] = serp
+		}
+
+		obs.Advance(progress.Fetch, len(batch))
+		return nil
+	})
 
-	for _, keyword := range keywords {
-		work <- keyword
+	// Once cancelled, batches in flight fail and the rest never start, so
+	// whatever the policy the data is incomplete and nothing is added
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if err == nil {
+			err = ctxErr
+		}
+		return report, fmt.Errorf("could not query SERPs: %w", err)
+	}
+	if len(report.Failed) > 0 {
+		var failed []error
+		for _, keyword := range report.FailedKeywords() {
+			failed = append(failed, InputError{
+				Input: keyword,
+				Err: fmt.Errorf("could not query after %d attempt(s): %w",
+					report.Attempts[keyword], report.Failed[keyword]),
+			})
+			if conf.policy == FailFast {
+				break
+			}
+		}
+		if conf.policy != Skip {
+			return report, BuildError{Errors: failed}
+		}
+		kd.merge(fetched)
+		return report, BuildError{Errors: failed, Skipped: true}
+	}
+	if err != nil {
+		return report, fmt.Errorf("could not query SERPs: %w", err)
 	}
 
-	wg.Wait()
-	close(work)
+	kd.merge(fetched)
+	return report, nil
+}
 
-	if len(errors) > 0 {
-		return BuildError{Errors: errors}
+// merge adds every SERP in other to kd
+func (kd KeywordData) merge(other KeywordData) {
+	for keyword, serp := range other {
+		kd[keyword] = serp
 	}
-	return nil
 }
 
-// BuildError represents one or more errors that could occur as the result
-// processing a directory
+// InputError is the failure to load a single input, a file or a keyword
+type InputError struct {
+	// Input is the path of the file or the keyword that failed
+	Input string
+	Err   error
+}
+
+func (ie InputError) Error() string {
+	return fmt.Sprintf("%s: %v", ie.Input, ie.Err)
+}
+
+// Unwrap returns the underlying error
+func (ie InputError) Unwrap() error {
+	return ie.Err
+}
+
+// BuildError represents one or more inputs that failed to load. Skipped is set
+// when the policy was Skip, so everything else was loaded and the errors may be
+// treated as warnings
 type BuildError struct {
-	Errors []error
+	Errors  []error
+	Skipped bool
 }
 
 func (be BuildError) Error() string {
-	return fmt.Sprintf("got %d error(s) - first was: %v",
-		len(be.Errors),
-		be.Errors[0])
+	if len(be.Errors) == 1 {
+		return be.Errors[0].Error()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d inputs failed:", len(be.Errors))
+	for _, err := range be.Errors {
+		fmt.Fprintf(&b, "\n\t%v", err)
+	}
+	return b.String()
+}
+
+// Is reports whether any failed input's error matches target, so errors.Is
+// looks through them
+func (be BuildError) Is(target error) bool {
+	for _, err := range be.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first failed input's error that matches target, so errors.As
+// looks through them
+func (be BuildError) As(target interface{}) bool {
+	for _, err := range be.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
 }
 
 // ProcessDirectory scans a directory for files containing SERP data and builds
-// a KeywordData from their contents
-func ProcessDirectory(directory string) (KeywordData, error) {
-	dir, err := os.Open(directory)
+// a KeywordData from their contents. Only files matching the include patterns,
+// *.json by default, are loaded, and subdirectories are ignored unless
+// WithRecursive is given. Under the Skip policy, the data loaded is returned
+// along with the BuildError
+func ProcessDirectory(directory string, options ...LoadOption) (KeywordData, error) {
+	paths, err := listFiles(directory, newLoadConfig(options))
 	if err != nil {
-		return nil, fmt.Errorf("could not open directory: %v", err)
+		return nil, err
 	}
-	defer dir.Close()
 
-	children, err := dir.Readdir(0)
+	kd := New()
+	err = kd.BuildFromDisk(paths, options...)
+	return kd, err
+}
+
+// LoadPath reads the rankings at p: a directory of per-keyword SERP files
+// loaded as by ProcessDirectory, a single bundle as written by WriteBundle, or
+// rankings saved in binary form by Save. The load options only apply to
+// directories
+func LoadPath(p string, options ...LoadOption) (KeywordData, error) {
+	info, err := os.Stat(p)
 	if err != nil {
-		return nil, fmt.Errorf("could not read directory: %v", err)
+		return nil, err
 	}
-
-	var paths []string
-	for _, p := range children {
-		paths = append(paths, path.Join(directory, p.Name()))
+	if info.IsDir() {
+		return ProcessDirectory(p, options...)
 	}
 
-	kd := New()
-	err = kd.BuildFromDisk(paths)
+	f, err := os.Open(p)
 	if err != nil {
-		log.Fatalf("could not build keyword data: %v", err)
+		return nil, fmt.Errorf("could not open file: %v", err)
 	}
+	defer f.Close()
 
-	return kd, nil
+	rdr := bufio.NewReader(f)
+	if IsBinary(rdr) {
+		return Load(rdr)
+	}
+	return ParseBundle(rdr)
 }
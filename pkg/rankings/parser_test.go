@@ -1,8 +1,12 @@
 package rankings
 
 import (
+	"io/ioutil"
 	"os"
+	"path"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestParse(t *testing.T) {
@@ -26,3 +30,40 @@ func TestParse(t *testing.T) {
 		t.Errorf("expected 20 entries, got %d", l)
 	}
 }
+
+func TestLoadPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rankings")
+	if err != nil {
+		t.Fatalf("could not create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kd := exportFixture()
+	delete(kd, "no results")
+	serps := path.Join(dir, "serps")
+	if err := kd.WriteDirectory(serps, Manifest{DomainID: 6290, FetchedAt: time.Now()}); err != nil {
+		t.Fatalf("could not write directory: %v", err)
+	}
+	bundle := path.Join(dir, "bundle.json")
+	if err := writeFile(bundle, kd.WriteBundle); err != nil {
+		t.Fatalf("could not write bundle: %v", err)
+	}
+	binary := path.Join(dir, "rankings.bin")
+	if err := writeFile(binary, kd.Save); err != nil {
+		t.Fatalf("could not save: %v", err)
+	}
+
+	for _, p := range []string{serps, bundle, binary} {
+		read, err := LoadPath(p)
+		if err != nil {
+			t.Fatalf("could not load %s: %v", p, err)
+		}
+		if !reflect.DeepEqual(read, kd) {
+			t.Errorf("expected %v from %s, got %v", kd, p, read)
+		}
+	}
+
+	if _, err := LoadPath(path.Join(dir, "missing")); err == nil {
+		t.Errorf("expected a missing path to fail")
+	}
+}
@@ -0,0 +1,86 @@
+package rankings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Decoder reads SERPs one at a time from a JSON array of SERP members, such as
+// a bundle written by WriteBundle or a single SERP file, holding no more than
+// one SERP in memory. Consecutive members for the same keyword make up a SERP,
+// so a keyword whose members are not contiguous is returned more than once
+type Decoder struct {
+	dec     *json.Decoder
+	started bool
+	pending SERPMember
+	buffer  bool
+	err     error
+}
+
+// NewDecoder creates a Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next SERP in the input, or io.EOF once there are none left.
+// Any other error is returned again by every later call
+func (d *Decoder) Next() (SERP, error) {
+	if d.err != nil {
+		return SERP{}, d.err
+	}
+	if !d.started {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return d.fail(fmt.Errorf("could not parse JSON: %v", err))
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return d.fail(fmt.Errorf("could not parse JSON: expected an array of SERP members"))
+		}
+		d.started = true
+	}
+
+	var serp SERP
+	for {
+		if d.buffer {
+			if serp.Length() > 0 && d.pending.Keyword != serp.Keyword {
+				return serp, nil
+			}
+			serp.Keyword = d.pending.Keyword
+			serp.Members = append(serp.Members, d.pending)
+			d.buffer = false
+		}
+
+		if !d.dec.More() {
+			break
+		}
+		d.pending = SERPMember{}
+		err := d.dec.Decode(&d.pending)
+		if err != nil {
+			return d.fail(fmt.Errorf("could not parse SERP member at offset %d: %v", d.dec.InputOffset(), err))
+		}
+		d.buffer = true
+	}
+
+	// More also stops at the end of the input, so make sure the array was
+	// closed rather than cut short
+	_, err := d.dec.Token()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return d.fail(fmt.Errorf("could not parse JSON: %v", err))
+	}
+
+	d.err = io.EOF
+	if serp.Length() > 0 {
+		return serp, nil
+	}
+	return SERP{}, io.EOF
+}
+
+// fail records err so later calls to Next return it too
+func (d *Decoder) fail(err error) (SERP, error) {
+	d.err = err
+	return SERP{}, err
+}
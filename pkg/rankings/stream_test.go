@@ -0,0 +1,129 @@
+package rankings
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bundleFixture encodes a bundle of n keywords with m members each
+func bundleFixture(t testing.TB, n, m int) []byte {
+	kd := New()
+	for i := 0; i < n; i++ {
+		kw := fmt.Sprintf("keyword %d", i)
+		serp := SERP{Keyword: kw}
+		for j := 0; j < m; j++ {
+			serp.Members = append(serp.Members, SERPMember{
+				Keyword:    kw,
+				Prominence: j + 1,
+				Domain:     fmt.Sprintf("competitor-%d.com", (i+j)%97),
+			})
+		}
+		kd[kw] = serp
+	}
+
+	var buf bytes.Buffer
+	if err := kd.WriteBundle(&buf); err != nil {
+		t.Fatalf("could not write bundle: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecoder(t *testing.T) {
+	kd := exportFixture()
+	delete(kd, "no results")
+	var buf bytes.Buffer
+	if err := kd.WriteBundle(&buf); err != nil {
+		t.Fatalf("could not write bundle: %v", err)
+	}
+
+	dec := NewDecoder(&buf)
+	for _, keyword := range kd.Keywords() {
+		serp, err := dec.Next()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(serp, kd[keyword]) {
+			t.Errorf("expected %v, got %v", kd[keyword], serp)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := dec.Next(); err != io.EOF {
+			t.Errorf("expected EOF after the last SERP, got %v", err)
+		}
+	}
+
+	for name, input := range map[string]string{
+		"empty":     "",
+		"object":    `{"keyword": "a"}`,
+		"truncated": `[{"keyword": "a", "prominence": 1, "competitor": "a.com"}`,
+		"member":    `[{"keyword": "a", "prominence": "first"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dec := NewDecoder(strings.NewReader(input))
+			var err error
+			for err == nil {
+				_, err = dec.Next()
+			}
+			if err == io.EOF {
+				t.Fatalf("expected an error for invalid input")
+			}
+			if _, again := dec.Next(); again != err {
+				t.Errorf("expected the error to be returned again, got %v", again)
+			}
+		})
+	}
+
+	if _, err := NewDecoder(strings.NewReader("[]")).Next(); err != io.EOF {
+		t.Errorf("expected EOF for an empty bundle, got %v", err)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	bundle := bundleFixture(b, 5000, 20)
+	b.SetBytes(int64(len(bundle)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(bytes.NewReader(bundle)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseBundle(b *testing.B) {
+	bundle := bundleFixture(b, 5000, 20)
+	b.SetBytes(int64(len(bundle)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseBundle(bytes.NewReader(bundle)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	bundle := bundleFixture(b, 5000, 20)
+	b.SetBytes(int64(len(bundle)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		dec := NewDecoder(bytes.NewReader(bundle))
+		for {
+			_, err := dec.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
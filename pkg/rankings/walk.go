@@ -0,0 +1,141 @@
+package rankings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DomainData is the keyword data of one domain, loaded from its own folder
+type DomainData struct {
+	// Domain is the name of the folder the data was loaded from
+	Domain   string
+	Keywords KeywordData
+}
+
+// ProcessDomains treats each top-level subfolder of directory as the dataset
+// of a separate domain, as in test-data/6290, and loads each one with
+// ProcessDirectory. Files directly in directory are ignored, and subfolders
+// matching an exclude pattern are left out. Domains are returned in name
+// order.
+//
+// The error policy applies across domains: under Skip every domain is
+// returned with the failures of all of them, under Collect nothing is
+// returned unless every domain loaded, and FailFast stops at the first domain
+// that fails
+func ProcessDomains(directory string, options ...LoadOption) ([]DomainData, error) {
+	conf := newLoadConfig(options)
+	children, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory: %v", err)
+	}
+
+	var domains []DomainData
+	var errors []error
+	for _, child := range children {
+		if !child.IsDir() {
+			continue
+		}
+		excluded, err := matchAny(conf.exclude, child.Name())
+		if err != nil {
+			return nil, err
+		}
+		if excluded {
+			continue
+		}
+
+		kd, err := ProcessDirectory(filepath.Join(directory, child.Name()), options...)
+		buildErr, ok := err.(BuildError)
+		if err != nil && !ok {
+			return nil, fmt.Errorf("could not load domain %s: %v", child.Name(), err)
+		}
+		if ok {
+			errors = append(errors, buildErr.Errors...)
+			if conf.policy == FailFast {
+				return nil, BuildError{Errors: errors}
+			}
+		}
+		domains = append(domains, DomainData{Domain: child.Name(), Keywords: kd})
+	}
+
+	if len(errors) > 0 {
+		if conf.policy != Skip {
+			return nil, BuildError{Errors: errors}
+		}
+		return domains, BuildError{Errors: errors, Skipped: true}
+	}
+	return domains, nil
+}
+
+// listFiles finds the SERP files in directory that the include and exclude
+// patterns select, descending into subdirectories if configured to. Manifests
+// are always left out. Paths are returned in lexical order
+func listFiles(directory string, conf loadConfig) ([]string, error) {
+	// Check the patterns up front, since a bad one is otherwise only reported
+	// if a file gets as far as being matched against it
+	for _, pattern := range append(conf.include, conf.exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+	}
+
+	var paths []string
+	err := filepath.Walk(directory, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == directory {
+			return nil
+		}
+
+		rel, err := filepath.Rel(directory, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		excluded, _ := matchAny(conf.exclude, rel)
+		if info.IsDir() {
+			if excluded || !conf.recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		included, _ := matchAny(conf.include, rel)
+		if excluded || !included || info.Name() == ManifestFile {
+			return nil
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory: %v", err)
+	}
+
+	return paths, nil
+}
+
+// matchAny reports whether the slash-separated relative path rel matches any
+// of the patterns. Patterns without a slash are matched against its last
+// element
+func matchAny(patterns []string, rel string) (bool, error) {
+	name := rel[strings.LastIndex(rel, "/")+1:]
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+
+		ok, err := filepath.Match(pattern, target)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
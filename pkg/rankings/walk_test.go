@@ -0,0 +1,123 @@
+package rankings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree writes a SERP file for each keyword at the given paths under dir,
+// creating folders as needed
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for p, keyword := range files {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("could not create folder: %v", err)
+		}
+		content := fmt.Sprintf(`[{"keyword": "%s", "prominence": 1, "competitor": "a.com"}]`, keyword)
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write fixture: %v", err)
+		}
+	}
+}
+
+func TestProcessDirectoryFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rankings")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		"a.json":             "a",
+		"notes.txt":          "notes",
+		"nested/b.json":      "b",
+		"nested/deep/c.json": "c",
+		"drafts/d.json":      "d",
+		"nested/e.draft":     "e",
+	})
+
+	tests := []struct {
+		name     string
+		options  []LoadOption
+		keywords []string
+	}{
+		{"top level", nil, []string{"a"}},
+		{"recursive", []LoadOption{WithRecursive()}, []string{"a", "b", "c", "d"}},
+		{"exclude folder", []LoadOption{WithRecursive(), WithExclude("drafts", "deep")}, []string{"a", "b"}},
+		{"exclude path", []LoadOption{WithRecursive(), WithExclude("nested/*.json")}, []string{"a", "c", "d"}},
+		{"include", []LoadOption{WithRecursive(), WithInclude("*.draft", "*.txt")}, []string{"e", "notes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kd, err := ProcessDirectory(dir, tt.options...)
+			if err != nil {
+				t.Fatalf("could not process directory: %v", err)
+			}
+			if got := kd.Keywords(); fmt.Sprint(got) != fmt.Sprint(tt.keywords) {
+				t.Errorf("expected keywords %v, got %v", tt.keywords, got)
+			}
+		})
+	}
+
+	if _, err := ProcessDirectory(dir, WithInclude("[")); err == nil {
+		t.Errorf("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestProcessDomains(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rankings")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		"stray.json":       "stray",
+		"6290/a.json":      "a",
+		"6290/b.json":      "b",
+		"17/c.json":        "c",
+		"17/market/d.json": "d",
+		"archive/e.json":   "e",
+	})
+
+	domains, err := ProcessDomains(dir, WithRecursive(), WithExclude("archive"))
+	if err != nil {
+		t.Fatalf("could not process domains: %v", err)
+	}
+
+	expected := map[string]string{
+		"17":   "[c d]",
+		"6290": "[a b]",
+	}
+	if len(domains) != len(expected) {
+		t.Fatalf("expected %d domains, got %d", len(expected), len(domains))
+	}
+	if domains[0].Domain != "17" {
+		t.Errorf("expected domains in name order, got %s first", domains[0].Domain)
+	}
+	for _, d := range domains {
+		if got := fmt.Sprint(d.Keywords.Keywords()); got != expected[d.Domain] {
+			t.Errorf("expected %s in domain %s, got %s", expected[d.Domain], d.Domain, got)
+		}
+	}
+
+	// A bad file fails only its own domain under Skip
+	if err := ioutil.WriteFile(filepath.Join(dir, "17", "bad.json"), []byte("["), 0644); err != nil {
+		t.Fatalf("could not write fixture: %v", err)
+	}
+	domains, err = ProcessDomains(dir, WithExclude("archive"))
+	buildErr, ok := err.(BuildError)
+	if !ok || !buildErr.Skipped || len(buildErr.Errors) != 1 {
+		t.Fatalf("expected one skipped file, got %v", err)
+	}
+	if len(domains) != 2 || len(domains[1].Keywords) != 2 {
+		t.Errorf("expected the other domain to load fully, got %v", domains)
+	}
+
+	if _, err := ProcessDomains(dir, WithErrorPolicy(Collect)); err == nil {
+		t.Errorf("expected collect to fail on the bad file")
+	}
+}
@@ -73,14 +73,25 @@ func rboRes(a, b rankings.SERP, p float64) float64 {
 
 // RBO point estimate based on extrapolating observed overlap
 func rboExt(a, b rankings.SERP, p float64) float64 {
+	return extrapolate(a, b, p, func(depth int) float64 {
+		return agreement(a, b, depth)
+	})
+}
+
+// extrapolate calculates the RBO point estimate with agree giving the
+// agreement of the two SERPs at each depth. An empty SERP overlaps nothing
+func extrapolate(a, b rankings.SERP, p float64, agree func(depth int) float64) float64 {
 	S, L := orderByLength(a, b)
 	s, l := S.Length(), L.Length()
-	xl := overlap(a, b, l)
-	xs := overlap(a, b, s)
+	if s == 0 {
+		return 0
+	}
+	xl := agree(l) * float64(s)
+	xs := agree(s) * float64(s)
 
 	var sum1, sum2 float64
 	for d := 1; d < l+1; d++ {
-		sum1 += math.Pow(p, float64(d)) * agreement(a, b, d)
+		sum1 += math.Pow(p, float64(d)) * agree(d)
 	}
 	for d := s + 1; d < l+1; d++ {
 		sum2 += math.Pow(p, float64(d)) * xs * float64(d-s) / float64(s) / float64(d)
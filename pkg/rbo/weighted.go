@@ -0,0 +1,109 @@
+package rbo
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// Weights sets how much each domain counts towards the overlap of two SERPs.
+// Domains not listed weigh 1
+type Weights map[string]float64
+
+// Weight returns the weight of a domain
+func (w Weights) Weight(domain string) float64 {
+	if weight, ok := w[domain]; ok {
+		return weight
+	}
+	return 1
+}
+
+// IDF weights every domain in kd by its inverse document frequency, so domains
+// ranking for most keywords, like wikipedia.org, count for little and domains
+// ranking for few count for a lot. For n keywords, df of which rank a domain,
+// its weight is ln((1 + n) / (1 + df)) + 1, smoothed so no domain weighs
+// nothing
+func IDF(kd rankings.KeywordData) Weights {
+	df := make(map[string]int)
+	for _, serp := range kd {
+		seen := make(map[string]bool)
+		for _, m := range serp.Members {
+			if !seen[m.Domain] {
+				seen[m.Domain] = true
+				df[m.Domain]++
+			}
+		}
+	}
+
+	n := float64(len(kd))
+	w := make(Weights, len(df))
+	for domain, count := range df {
+		w[domain] = math.Log((1+n)/(1+float64(count))) + 1
+	}
+	return w
+}
+
+// Stoplist is a set of domains to leave out of SERPs before comparing them,
+// such as generic authorities that rank for nearly everything
+type Stoplist map[string]bool
+
+// NewStoplist creates a Stoplist of the given domains
+func NewStoplist(domains ...string) Stoplist {
+	s := make(Stoplist, len(domains))
+	for _, domain := range domains {
+		s[domain] = true
+	}
+	return s
+}
+
+// Filter returns a copy of the SERP without the stopped domains, so the ones
+// ranked below them move up
+func (s Stoplist) Filter(serp rankings.SERP) rankings.SERP {
+	filtered := rankings.SERP{Keyword: serp.Keyword}
+	for _, m := range serp.Members {
+		if !s[m.Domain] {
+			filtered.Members = append(filtered.Members, m)
+		}
+	}
+	return filtered
+}
+
+// WeightedRBO calculates the extrapolated rank-biased overlap of 2 SERPs, like
+// the ext value of RBO, with each domain's share of the agreement between them
+// scaled by its weight. With every weight 1 it equals RBO's ext
+func WeightedRBO(a, b rankings.SERP, p float64, w Weights) (float64, error) {
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("p must be between 0 and 1")
+	}
+
+	return extrapolate(a, b, p, func(depth int) float64 {
+		return weightedAgreement(a, b, depth, w)
+	}), nil
+}
+
+// weightedAgreement calculates the weight of the domains shared by the two
+// lists at a given depth as a proportion of their mean total weight
+func weightedAgreement(a, b rankings.SERP, depth int, w Weights) float64 {
+	aMembers := a.Members[:min(depth, len(a.Members))]
+	bMembers := b.Members[:min(depth, len(b.Members))]
+
+	inA := make(map[string]bool, len(aMembers))
+	var total, shared float64
+	for _, m := range aMembers {
+		inA[m.Domain] = true
+		total += w.Weight(m.Domain)
+	}
+	for _, m := range bMembers {
+		weight := w.Weight(m.Domain)
+		total += weight
+		if inA[m.Domain] {
+			shared += weight
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return 2 * shared / total
+}
@@ -0,0 +1,96 @@
+package rbo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+func serp(keyword string, domains ...string) rankings.SERP {
+	s := rankings.SERP{Keyword: keyword}
+	for i, d := range domains {
+		s.Members = append(s.Members, rankings.SERPMember{Keyword: keyword, Prominence: i + 1, Domain: d})
+	}
+	return s
+}
+
+func TestWeightedRBO(t *testing.T) {
+	kd := rankings.KeywordData{
+		"parking":        serp("parking", "wiki.org", "a.com", "b.com", "c.com"),
+		"garage parking": serp("garage parking", "wiki.org", "b.com", "a.com", "d.com"),
+		"condo":          serp("condo", "wiki.org", "x.com", "y.com"),
+		"condo prices":   serp("condo prices", "wiki.org", "y.com", "x.com", "z.com", "q.com"),
+	}
+
+	// Unweighted, it matches plain RBO whatever the lengths
+	for _, a := range kd {
+		for _, b := range kd {
+			_, _, ext, err := RBO(a, b, 0.9)
+			if err != nil {
+				t.Fatalf("could not compute RBO: %v", err)
+			}
+			weighted, err := WeightedRBO(a, b, 0.9, nil)
+			if err != nil {
+				t.Fatalf("could not compute weighted RBO: %v", err)
+			}
+			if math.Abs(ext-weighted) > 1e-12 {
+				t.Errorf("%s/%s: expected %v without weights, got %v", a.Keyword, b.Keyword, ext, weighted)
+			}
+		}
+	}
+
+	w := IDF(kd)
+	if w.Weight("wiki.org") != 1 {
+		t.Errorf("expected a domain in every SERP to weigh 1, got %v", w.Weight("wiki.org"))
+	}
+	if want := math.Log(5.0/2.0) + 1; math.Abs(w.Weight("z.com")-want) > 1e-12 {
+		t.Errorf("expected a domain in one SERP to weigh %v, got %v", want, w.Weight("z.com"))
+	}
+
+	score := func(w Weights, a, b string) float64 {
+		s, err := WeightedRBO(kd[a], kd[b], 0.9, w)
+		if err != nil {
+			t.Fatalf("could not compute weighted RBO: %v", err)
+		}
+		return s
+	}
+	unrelated := score(nil, "parking", "condo")
+	if idf := score(w, "parking", "condo"); idf >= unrelated {
+		t.Errorf("expected IDF to lower similarity through a common domain, got %v from %v", idf, unrelated)
+	}
+	if related := score(w, "parking", "garage parking"); related <= unrelated {
+		t.Errorf("expected related keywords to stay similar, got %v", related)
+	}
+	if s := score(w, "parking", "parking"); math.Abs(s-1) > 1e-12 {
+		t.Errorf("expected a SERP to match itself, got %v", s)
+	}
+
+	if _, err := WeightedRBO(kd["parking"], kd["condo"], 1.5, w); err == nil {
+		t.Errorf("expected an invalid p to be rejected")
+	}
+}
+
+func TestStoplist(t *testing.T) {
+	stop := NewStoplist("wiki.org", "youtube.com")
+	filtered := stop.Filter(serp("parking", "wiki.org", "a.com", "youtube.com", "b.com"))
+	if filtered.Keyword != "parking" || filtered.Length() != 2 ||
+		filtered.Members[0].Domain != "a.com" || filtered.Members[1].Domain != "b.com" {
+		t.Errorf("expected only a.com and b.com, in order, got %v", filtered)
+	}
+
+	a := stop.Filter(serp("parking", "wiki.org", "a.com"))
+	b := stop.Filter(serp("condo", "wiki.org", "x.com"))
+	_, _, ext, err := RBO(a, b, 0.9)
+	if err != nil {
+		t.Fatalf("could not compute RBO: %v", err)
+	}
+	if ext != 0 {
+		t.Errorf("expected no similarity through a stopped domain, got %v", ext)
+	}
+
+	_, _, ext, _ = RBO(stop.Filter(serp("video", "youtube.com")), a, 0.9)
+	if ext != 0 {
+		t.Errorf("expected no similarity for a SERP of stopped domains, got %v", ext)
+	}
+}
@@ -0,0 +1,3 @@
+// Package snapshot stores dated captures of SERP data so clusters can be
+// computed against any point in history, not just the latest rankings.
+package snapshot
@@ -0,0 +1,225 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DateFormat is the layout used for snapshot dates. Snapshots are recorded at
+// most once per day for a dataset
+const DateFormat = "2006-01-02"
+
+// Store records dated KeywordData captures per domain and market in a single
+// file on disk
+type Store struct {
+	db *bolt.DB
+}
+
+// Dataset identifies the domain and market a snapshot was captured for. A
+// MarketID of 0 means rankings from all markets
+type Dataset struct {
+	DomainID int
+	MarketID int
+}
+
+// Snapshot is the keyword data captured for a dataset on a given date
+type Snapshot struct {
+	Dataset
+	Date        time.Time
+	KeywordData rankings.KeywordData
+}
+
+// Open opens the store at path, creating it if it does not exist
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open snapshot store: %v", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the store file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Save records the keyword data captured for the dataset on date, replacing
+// any snapshot already recorded for that day
+func (s *Store) Save(ds Dataset, date time.Time, kd rankings.KeywordData) error {
+	data, err := json.Marshal(kd)
+	if err != nil {
+		return fmt.Errorf("could not encode keyword data: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(ds.key())
+		if err != nil {
+			return fmt.Errorf("could not create dataset bucket: %v", err)
+		}
+
+		return b.Put([]byte(FormatDate(date)), data)
+	})
+}
+
+// Datasets lists the datasets with at least one snapshot
+func (s *Store) Datasets() ([]Dataset, error) {
+	var datasets []Dataset
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			ds, err := parseKey(name)
+			if err != nil {
+				return err
+			}
+			datasets = append(datasets, ds)
+			return nil
+		})
+	})
+
+	sort.Slice(datasets, func(i, j int) bool {
+		if datasets[i].DomainID != datasets[j].DomainID {
+			return datasets[i].DomainID < datasets[j].DomainID
+		}
+		return datasets[i].MarketID < datasets[j].MarketID
+	})
+	return datasets, err
+}
+
+// Dates lists the dates with a snapshot for the dataset, oldest first
+func (s *Store) Dates(ds Dataset) ([]time.Time, error) {
+	var dates []time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ds.key())
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, _ []byte) error {
+			date, err := ParseDate(string(k))
+			if err != nil {
+				return err
+			}
+			dates = append(dates, date)
+			return nil
+		})
+	})
+
+	return dates, err
+}
+
+// Load reads the snapshot recorded for the dataset on date
+func (s *Store) Load(ds Dataset, date time.Time) (Snapshot, error) {
+	snap := Snapshot{Dataset: ds, Date: truncate(date)}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ds.key())
+		if b == nil {
+			return fmt.Errorf("no snapshots for domain %d, market %d", ds.DomainID, ds.MarketID)
+		}
+
+		data := b.Get([]byte(FormatDate(date)))
+		if data == nil {
+			return fmt.Errorf("no snapshot for domain %d, market %d on %s",
+				ds.DomainID, ds.MarketID, FormatDate(date))
+		}
+
+		return decode(data, &snap)
+	})
+
+	return snap, err
+}
+
+// LoadRange reads all snapshots recorded for the dataset between from and to,
+// inclusive, oldest first
+func (s *Store) LoadRange(ds Dataset, from, to time.Time) ([]Snapshot, error) {
+	var snaps []Snapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ds.key())
+		if b == nil {
+			return nil
+		}
+
+		// Dates sort lexically in the same order as chronologically, so we can
+		// seek to the start of the range and stop at the end of it
+		min := []byte(FormatDate(from))
+		max := []byte(FormatDate(to))
+		c := b.Cursor()
+		for k, v := c.Seek(min); k != nil && string(k) <= string(max); k, v = c.Next() {
+			date, err := ParseDate(string(k))
+			if err != nil {
+				return err
+			}
+
+			snap := Snapshot{Dataset: ds, Date: date}
+			if err := decode(v, &snap); err != nil {
+				return err
+			}
+			snaps = append(snaps, snap)
+		}
+
+		return nil
+	})
+
+	return snaps, err
+}
+
+// FormatDate formats a snapshot date
+func FormatDate(date time.Time) string {
+	return date.UTC().Format(DateFormat)
+}
+
+// ParseDate parses a snapshot date
+func ParseDate(value string) (time.Time, error) {
+	date, err := time.Parse(DateFormat, value)
+	if err != nil {
+		return date, fmt.Errorf("could not parse date '%s': %v", value, err)
+	}
+
+	return date, nil
+}
+
+func truncate(date time.Time) time.Time {
+	d, _ := ParseDate(FormatDate(date))
+	return d
+}
+
+func decode(data []byte, snap *Snapshot) error {
+	err := json.Unmarshal(data, &snap.KeywordData)
+	if err != nil {
+		return fmt.Errorf("could not parse snapshot for %s: %v", FormatDate(snap.Date), err)
+	}
+
+	return nil
+}
+
+func (ds Dataset) key() []byte {
+	return []byte(fmt.Sprintf("domain:%d/market:%d", ds.DomainID, ds.MarketID))
+}
+
+func parseKey(key []byte) (Dataset, error) {
+	var ds Dataset
+	parts := strings.Split(string(key), "/")
+	if len(parts) != 2 ||
+		!strings.HasPrefix(parts[0], "domain:") ||
+		!strings.HasPrefix(parts[1], "market:") {
+		return ds, fmt.Errorf("unexpected dataset key '%s'", key)
+	}
+
+	var err error
+	ds.DomainID, err = strconv.Atoi(strings.TrimPrefix(parts[0], "domain:"))
+	if err != nil {
+		return ds, fmt.Errorf("unexpected dataset key '%s': %v", key, err)
+	}
+	ds.MarketID, err = strconv.Atoi(strings.TrimPrefix(parts[1], "market:"))
+	if err != nil {
+		return ds, fmt.Errorf("unexpected dataset key '%s': %v", key, err)
+	}
+
+	return ds, nil
+}
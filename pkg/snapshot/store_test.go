@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(filepath.Join(dir, "snapshots.db"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	ds := Dataset{DomainID: 6290}
+	for _, day := range []string{"2020-07-03", "2020-07-01", "2020-07-02"} {
+		date, _ := ParseDate(day)
+		kd := rankings.New()
+		kd["condo parking"] = rankings.SERP{
+			Keyword: "condo parking",
+			Members: []rankings.SERPMember{
+				{Keyword: "condo parking", Prominence: 1, Domain: day + ".com"},
+			},
+		}
+		if err := s.Save(ds, date, kd); err != nil {
+			t.Fatalf("could not save %s: %v", day, err)
+		}
+	}
+	if err := s.Save(Dataset{DomainID: 1, MarketID: 2}, mustDate("2020-07-01"), rankings.New()); err != nil {
+		t.Fatalf("could not save other dataset: %v", err)
+	}
+
+	datasets, err := s.Datasets()
+	if err != nil {
+		t.Fatalf("could not list datasets: %v", err)
+	}
+	if len(datasets) != 2 || datasets[0] != (Dataset{DomainID: 1, MarketID: 2}) || datasets[1] != ds {
+		t.Errorf("unexpected datasets %v", datasets)
+	}
+
+	dates, err := s.Dates(ds)
+	if err != nil {
+		t.Fatalf("could not list dates: %v", err)
+	}
+	if len(dates) != 3 || FormatDate(dates[0]) != "2020-07-01" {
+		t.Errorf("expected 3 dates starting 2020-07-01, got %v", dates)
+	}
+
+	snap, err := s.Load(ds, mustDate("2020-07-02"))
+	if err != nil {
+		t.Fatalf("could not load snapshot: %v", err)
+	}
+	if d := snap.KeywordData["condo parking"].Members[0].Domain; d != "2020-07-02.com" {
+		t.Errorf("expected '2020-07-02.com', got %s", d)
+	}
+
+	if _, err := s.Load(ds, mustDate("2020-06-30")); err == nil {
+		t.Errorf("expected an error loading a missing date")
+	}
+
+	snaps, err := s.LoadRange(ds, mustDate("2020-07-02"), mustDate("2020-07-10"))
+	if err != nil {
+		t.Fatalf("could not load range: %v", err)
+	}
+	if len(snaps) != 2 || FormatDate(snaps[1].Date) != "2020-07-03" {
+		t.Errorf("expected snapshots for 07-02 and 07-03, got %d", len(snaps))
+	}
+}
+
+func mustDate(value string) time.Time {
+	date, err := ParseDate(value)
+	if err != nil {
+		panic(err)
+	}
+	return date
+}
@@ -0,0 +1,7 @@
+// Package sqlite keeps SERP datasets in a single portable SQLite file and
+// queries them through the same interface as the product database, so local
+// workflows get indexed queries without running any services. The SERPs it
+// builds are not comparable with the product database's: competitors are
+// ranked by average prominence rather than Wilson score, and without a date or
+// range only the latest capture is used rather than every date.
+package sqlite
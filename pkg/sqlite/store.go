@@ -0,0 +1,401 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	// go-sqlite3 provides the sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+// DateFormat is the layout dates are stored in, so they sort as text
+const DateFormat = "2006-01-02"
+
+const schema = `
+	CREATE TABLE IF NOT EXISTS keywords (
+		keyword_id INTEGER PRIMARY KEY,
+		domain_id  INTEGER NOT NULL,
+		name       TEXT NOT NULL,
+		UNIQUE (domain_id, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS serp_members (
+		keyword_id INTEGER NOT NULL REFERENCES keywords (keyword_id) ON DELETE CASCADE,
+		market_id  INTEGER NOT NULL,
+		date       TEXT NOT NULL,
+		prominence INTEGER NOT NULL,
+		competitor TEXT NOT NULL,
+		PRIMARY KEY (keyword_id, market_id, date, prominence)
+	);
+
+	CREATE INDEX IF NOT EXISTS serp_members_date ON serp_members (keyword_id, date);
+`
+
+// serpsQuery takes the place of the product database's batch SERP query. SERPs
+// captured for several markets or dates are combined by averaging each
+// competitor's prominence, and competitors are then ranked within each keyword
+// by that average, where the product database ranks by Wilson score. Without a
+// date or range, only the latest capture for each keyword and market is used,
+// where the product database uses every date
+const serpsQuery = `
+	WITH selected AS (
+		SELECT k.name AS keyword, m.competitor, m.prominence
+		FROM keywords k
+		JOIN serp_members m USING (keyword_id)
+		WHERE k.domain_id = ?
+		AND k.name IN ({{keywords}}){{filters}}
+	), competitors AS (
+		SELECT keyword, competitor, avg(prominence) AS avg_rank
+		FROM selected
+		GROUP BY keyword, competitor
+	), ranked AS (
+		SELECT
+			keyword,
+			row_number() OVER (PARTITION BY keyword ORDER BY avg_rank, competitor) AS prominence,
+			competitor
+		FROM competitors
+		WHERE avg_rank <= ?
+	)
+	SELECT keyword, prominence, competitor
+	FROM ranked
+	WHERE prominence <= ?
+	ORDER BY keyword, prominence
+`
+
+const keywordsQuery = `
+	SELECT k.name
+	FROM keywords k
+	WHERE k.domain_id = ?{{filters}}
+	ORDER BY k.name
+`
+
+// latest limits SERP members to the latest capture of their keyword and market
+const latest = `
+		AND m.date = (
+			SELECT max(date) FROM serp_members l
+			WHERE l.keyword_id = m.keyword_id AND l.market_id = m.market_id
+		)`
+
+// Store is a SERP dataset in a SQLite file. It implements data.Source
+type Store struct {
+	db        *sql.DB
+	batchSize int
+}
+
+var _ data.Source = (*Store)(nil)
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithBatchSize configures the maximum number of keywords to fetch SERPs for in
+// a single query
+func WithBatchSize(size int) Option {
+	return func(s *Store) {
+		s.batchSize = size
+	}
+}
+
+// Open opens the dataset at path, creating it if it does not exist
+func Open(path string, options ...Option) (*Store, error) {
+	s := &Store{batchSize: 500}
+	for _, opt := range options {
+		opt(s)
+	}
+
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("could not open dataset: %v", err)
+	}
+
+	_, err = db.Exec(schema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create schema: %v", err)
+	}
+
+	s.db = db
+	return s, nil
+}
+
+// Close releases the dataset file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Import records the SERPs in kd as captured for a domain and market on date,
+// replacing any SERPs already recorded for them. Keywords without rankings are
+// recorded too, so they are still listed by FetchKeywords
+func (s *Store) Import(ctx context.Context, domainID int, marketID int, date time.Time, kd rankings.KeywordData) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	day := date.Format(DateFormat)
+	for _, keyword := range kd.Keywords() {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO keywords (domain_id, name) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			domainID, keyword)
+		if err != nil {
+			return fmt.Errorf("could not insert keyword %s: %v", keyword, err)
+		}
+
+		var keywordID int64
+		err = tx.QueryRowContext(ctx,
+			`SELECT keyword_id FROM keywords WHERE domain_id = ? AND name = ?`,
+			domainID, keyword).Scan(&keywordID)
+		if err != nil {
+			return fmt.Errorf("could not find keyword %s: %v", keyword, err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM serp_members WHERE keyword_id = ? AND market_id = ? AND date = ?`,
+			keywordID, marketID, day)
+		if err != nil {
+			return fmt.Errorf("could not replace SERP for %s: %v", keyword, err)
+		}
+
+		for _, m := range kd[keyword].Members {
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO serp_members (keyword_id, market_id, date, prominence, competitor) VALUES (?, ?, ?, ?, ?)`,
+				keywordID, marketID, day, m.Prominence, m.Domain)
+			if err != nil {
+				return fmt.Errorf("could not insert SERP for %s: %v", keyword, err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("could not commit import: %v", err)
+	}
+	return nil
+}
+
+// Domain summarizes the data recorded for a domain
+type Domain struct {
+	DomainID int
+	Keywords int
+	Markets  []int
+	Dates    []time.Time
+}
+
+// Domains lists the domains in the dataset
+func (s *Store) Domains(ctx context.Context) ([]Domain, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT domain_id, count(*) FROM keywords GROUP BY domain_id ORDER BY domain_id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list domains: %v", err)
+	}
+
+	var domains []Domain
+	for rows.Next() {
+		var d Domain
+		err := rows.Scan(&d.DomainID, &d.Keywords)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not parse domain: %v", err)
+		}
+		domains = append(domains, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list domains: %v", err)
+	}
+
+	for i := range domains {
+		d := &domains[i]
+		err := s.distinct(ctx, "market_id", d.DomainID, func(rows *sql.Rows) error {
+			var id int
+			err := rows.Scan(&id)
+			d.Markets = append(d.Markets, id)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list markets: %v", err)
+		}
+
+		err = s.distinct(ctx, "date", d.DomainID, func(rows *sql.Rows) error {
+			var day string
+			if err := rows.Scan(&day); err != nil {
+				return err
+			}
+			t, err := time.Parse(DateFormat, day)
+			d.Dates = append(d.Dates, t)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list dates: %v", err)
+		}
+	}
+
+	return domains, nil
+}
+
+// distinct calls eachRow for each distinct value of a serp_members column for
+// a domain, in order
+func (s *Store) distinct(ctx context.Context, column string, domainID int, eachRow func(*sql.Rows) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT m.`+column+`
+		FROM serp_members m
+		JOIN keywords k USING (keyword_id)
+		WHERE k.domain_id = ?
+		ORDER BY m.`+column, domainID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := eachRow(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// FetchKeywords loads the keywords for a given domain. If opts limits markets
+// or dates, only keywords with SERPs captured for them are included
+func (s *Store) FetchKeywords(ctx context.Context, domainID int, opts data.QueryOptions) ([]string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid query options: %v", err)
+	}
+
+	args := []interface{}{domainID}
+	var where string
+	if filters, fargs := filters(opts, false); filters != "" {
+		where = "\n\t\tAND EXISTS (SELECT 1 FROM serp_members m WHERE m.keyword_id = k.keyword_id" + filters + ")"
+		args = append(args, fargs...)
+	}
+
+	rows, err := s.db.QueryContext(ctx, strings.Replace(keywordsQuery, "{{filters}}", where, 1), args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query the dataset: %v", err)
+	}
+	defer rows.Close()
+
+	var keywords []string
+	for rows.Next() {
+		var kw string
+		err := rows.Scan(&kw)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse keyword result: %v", err)
+		}
+		keywords = append(keywords, kw)
+	}
+
+	return keywords, rows.Err()
+}
+
+// FetchSERPs loads prominent SERP members for many keywords, in batches.
+// eachBatch is called once per batch with the keywords in it and their rows,
+// ordered by keyword and prominence. Keywords without rankings have no rows
+func (s *Store) FetchSERPs(ctx context.Context, domainID int, keywords []string, opts data.QueryOptions, eachBatch func(batch []string, rows []data.SERPRow) error) (data.FetchReport, error) {
+	report := data.NewFetchReport()
+	if err := opts.Validate(); err != nil {
+		return report, fmt.Errorf("invalid query options: %v", err)
+	}
+
+	size := s.batchSize
+	if size <= 0 {
+		size = len(keywords)
+	}
+	for start := 0; start < len(keywords); start += size {
+		end := start + size
+		if end > len(keywords) {
+			end = len(keywords)
+		}
+		batch := keywords[start:end]
+
+		rows, err := s.fetchBatch(ctx, domainID, batch, opts)
+		if err == nil {
+			err = eachBatch(batch, rows)
+		}
+		for _, kw := range batch {
+			report.Attempts[kw]++
+			if err != nil {
+				report.Failed[kw] = err
+			}
+		}
+		if ctx.Err() != nil {
+			return report, fmt.Errorf("fetching SERPs stopped: %w", ctx.Err())
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("%d of %d keywords failed", len(report.Failed), len(keywords))
+	}
+	return report, nil
+}
+
+// fetchBatch runs the SERP query for a set of keywords
+func (s *Store) fetchBatch(ctx context.Context, domainID int, keywords []string, opts data.QueryOptions) ([]data.SERPRow, error) {
+	args := []interface{}{domainID}
+	for _, kw := range keywords {
+		args = append(args, kw)
+	}
+	filters, fargs := filters(opts, true)
+	args = append(args, fargs...)
+	args = append(args, opts.MaxRank, opts.Limit)
+
+	q := strings.NewReplacer(
+		"{{keywords}}", strings.TrimSuffix(strings.Repeat("?, ", len(keywords)), ", "),
+		"{{filters}}", filters,
+	).Replace(serpsQuery)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query the dataset: %v", err)
+	}
+	defer rows.Close()
+
+	var result []data.SERPRow
+	for rows.Next() {
+		var r data.SERPRow
+		err := rows.Scan(&r.Keyword, &r.Prominence, &r.Domain)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing row: %v", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// filters builds the SQL conditions on serp_members m for the market and date
+// options, along with their arguments. With latestByDefault set, SERPs default
+// to the latest capture when no date or range is given
+func filters(opts data.QueryOptions, latestByDefault bool) (string, []interface{}) {
+	var sql string
+	var args []interface{}
+
+	if len(opts.MarketIDs) > 0 {
+		sql += "\n\t\tAND m.market_id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(opts.MarketIDs)), ", ") + ")"
+		for _, id := range opts.MarketIDs {
+			args = append(args, id)
+		}
+	}
+	if !opts.Date.IsZero() {
+		sql += "\n\t\tAND m.date = ?"
+		args = append(args, opts.Date.Format(DateFormat))
+	}
+	if !opts.From.IsZero() {
+		sql += "\n\t\tAND m.date >= ?"
+		args = append(args, opts.From.Format(DateFormat))
+	}
+	if !opts.To.IsZero() {
+		sql += "\n\t\tAND m.date <= ?"
+		args = append(args, opts.To.Format(DateFormat))
+	}
+	if latestByDefault && opts.Date.IsZero() && opts.From.IsZero() && opts.To.IsZero() {
+		sql += latest
+	}
+
+	return sql, args
+}
@@ -0,0 +1,149 @@
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/data"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+)
+
+func day(s string) time.Time {
+	t, _ := time.Parse(DateFormat, s)
+	return t
+}
+
+func serps(domains map[string][]string) rankings.KeywordData {
+	kd := rankings.New()
+	for kw, ds := range domains {
+		serp := rankings.SERP{Keyword: kw}
+		for i, d := range ds {
+			serp.Members = append(serp.Members, rankings.SERPMember{Keyword: kw, Prominence: i + 1, Domain: d})
+		}
+		kd[kw] = serp
+	}
+	return kd
+}
+
+// fetch returns each keyword's competitors in order
+func fetch(t *testing.T, s *Store, keywords []string, opts data.QueryOptions) map[string][]string {
+	result := make(map[string][]string)
+	_, err := s.FetchSERPs(context.Background(), 6290, keywords, opts, func(batch []string, rows []data.SERPRow) error {
+		for _, r := range rows {
+			result[r.Keyword] = append(result[r.Keyword], r.Domain)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return result
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(filepath.Join(dir, "serps.db"), WithBatchSize(1))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	imports := []struct {
+		market int
+		date   string
+		kd     rankings.KeywordData
+	}{
+		{1, "2020-07-01", serps(map[string][]string{"condo parking": {"a.com", "b.com", "c.com"}, "park share": {"x.com"}})},
+		{1, "2020-07-02", serps(map[string][]string{"condo parking": {"c.com", "b.com", "a.com"}})},
+		{2, "2020-07-01", serps(map[string][]string{"condo parking": {"b.com", "a.com"}, "no results": nil})},
+	}
+	for _, i := range imports {
+		if err := s.Import(ctx, 6290, i.market, day(i.date), i.kd); err != nil {
+			t.Fatalf("could not import: %v", err)
+		}
+	}
+	// Importing again replaces the SERP rather than adding to it
+	if err := s.Import(ctx, 6290, imports[0].market, day(imports[0].date), imports[0].kd); err != nil {
+		t.Fatalf("could not import: %v", err)
+	}
+
+	keywords, err := s.FetchKeywords(ctx, 6290, data.DefaultQueryOptions())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if expected := []string{"condo parking", "no results", "park share"}; !reflect.DeepEqual(keywords, expected) {
+		t.Errorf("expected keywords %v, got %v", expected, keywords)
+	}
+
+	opts := data.DefaultQueryOptions()
+	opts.MarketIDs = []int{2}
+	keywords, _ = s.FetchKeywords(ctx, 6290, opts)
+	if expected := []string{"condo parking"}; !reflect.DeepEqual(keywords, expected) {
+		t.Errorf("expected keywords ranking in market 2 %v, got %v", expected, keywords)
+	}
+
+	all := []string{"condo parking", "no results", "park share"}
+	tt := []struct {
+		name     string
+		opts     func(o *data.QueryOptions)
+		expected map[string][]string
+	}{
+		{
+			// Latest for market 1 is c, b, a and for market 2 is b, a, so b
+			// averages 1.5, a 2.5 and c 1
+			name:     "latest in every market",
+			opts:     func(o *data.QueryOptions) {},
+			expected: map[string][]string{"condo parking": {"c.com", "b.com", "a.com"}, "park share": {"x.com"}},
+		},
+		{
+			name:     "single date and market",
+			opts:     func(o *data.QueryOptions) { o.Date = day("2020-07-01"); o.MarketIDs = []int{1} },
+			expected: map[string][]string{"condo parking": {"a.com", "b.com", "c.com"}, "park share": {"x.com"}},
+		},
+		{
+			// b averages 5/3 over every capture, beating a and c at 2
+			name:     "range averaged with limit",
+			opts:     func(o *data.QueryOptions) { o.From = day("2020-07-01"); o.Limit = 1 },
+			expected: map[string][]string{"condo parking": {"b.com"}, "park share": {"x.com"}},
+		},
+		{
+			name:     "max rank",
+			opts:     func(o *data.QueryOptions) { o.Date = day("2020-07-02"); o.MaxRank = 2 },
+			expected: map[string][]string{"condo parking": {"c.com", "b.com"}},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := data.DefaultQueryOptions()
+			tc.opts(&opts)
+			if got := fetch(t, s, all, opts); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+
+	domains, err := s.Domains(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []Domain{{
+		DomainID: 6290,
+		Keywords: 3,
+		Markets:  []int{1, 2},
+		Dates:    []time.Time{day("2020-07-01"), day("2020-07-02")},
+	}}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Errorf("expected %+v, got %+v", expected, domains)
+	}
+}
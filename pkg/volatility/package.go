@@ -0,0 +1,3 @@
+// Package volatility measures how much the SERPs for keywords churn over time,
+// using the rank-biased overlap between consecutive snapshots.
+package volatility
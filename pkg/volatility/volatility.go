@@ -0,0 +1,141 @@
+package volatility
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rbo"
+	"github.com/thedahv/keyword-cluster-finder/pkg/snapshot"
+)
+
+// Score is the volatility of a keyword's SERP over a series of snapshots. A
+// volatility of 0 means the SERP never changed, and 1 means consecutive SERPs
+// had nothing in common
+type Score struct {
+	Keyword    string  `json:"keyword"`
+	Volatility float64 `json:"volatility"`
+	// Changes is the number of consecutive snapshot pairs the score is
+	// averaged over
+	Changes int `json:"changes"`
+}
+
+// Point is the mean volatility across all keywords between a snapshot and the
+// one before it. Spikes suggest a search engine algorithm update
+type Point struct {
+	Date       time.Time `json:"date"`
+	Volatility float64   `json:"volatility"`
+	Keywords   int       `json:"keywords"`
+}
+
+// Analysis holds the volatility of each keyword and over time
+type Analysis struct {
+	Keywords map[string]Score `json:"keywords"`
+	Timeline []Point          `json:"timeline"`
+}
+
+// Analyze computes the volatility of every keyword across snapshots, which
+// must be ordered oldest first. Volatility between two dates is 1 minus the
+// extrapolated RBO of the keyword's SERPs on those dates. Keywords missing or
+// with an empty SERP on either date are skipped for that pair
+func Analyze(snaps []snapshot.Snapshot, p float64) (Analysis, error) {
+	a := Analysis{Keywords: make(map[string]Score)}
+	sums := make(map[string]float64)
+
+	for i := 1; i < len(snaps); i++ {
+		prev, cur := snaps[i-1].KeywordData, snaps[i].KeywordData
+		point := Point{Date: snaps[i].Date}
+
+		var total float64
+		for _, keyword := range cur.Keywords() {
+			v, ok, err := change(prev[keyword], cur[keyword], p)
+			if err != nil {
+				return a, fmt.Errorf("could not compare %s on %s: %v",
+					keyword, snapshot.FormatDate(snaps[i].Date), err)
+			}
+			if !ok {
+				continue
+			}
+
+			sums[keyword] += v
+			s := a.Keywords[keyword]
+			s.Keyword = keyword
+			s.Changes++
+			a.Keywords[keyword] = s
+
+			total += v
+			point.Keywords++
+		}
+
+		if point.Keywords > 0 {
+			point.Volatility = total / float64(point.Keywords)
+		}
+		a.Timeline = append(a.Timeline, point)
+	}
+
+	for keyword, s := range a.Keywords {
+		s.Volatility = sums[keyword] / float64(s.Changes)
+		a.Keywords[keyword] = s
+	}
+
+	return a, nil
+}
+
+// Ranked lists keyword scores from most to least volatile
+func (a Analysis) Ranked() []Score {
+	scores := make([]Score, 0, len(a.Keywords))
+	for _, s := range a.Keywords {
+		scores = append(scores, s)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Volatility != scores[j].Volatility {
+			return scores[i].Volatility > scores[j].Volatility
+		}
+		return scores[i].Keyword < scores[j].Keyword
+	})
+
+	return scores
+}
+
+// Apply sets the volatility of each cluster to the mean volatility of its
+// keywords. Keywords without a score do not count toward the mean, and a
+// cluster with none is left unmeasured
+func (a Analysis) Apply(clusters []graph.ClusterGroup) {
+	for i, c := range clusters {
+		var sum float64
+		var n int
+		for _, kw := range c.Keywords {
+			s, ok := a.Keywords[kw]
+			if !ok {
+				continue
+			}
+			sum += s.Volatility
+			n++
+		}
+
+		clusters[i].Volatility = nil
+		if n > 0 {
+			mean := sum / float64(n)
+			clusters[i].Volatility = &mean
+		}
+	}
+}
+
+// change computes the volatility between two SERPs for the same keyword. The
+// second return value is false if the SERPs cannot be compared
+func change(a, b rankings.SERP, p float64) (float64, bool, error) {
+	if a.Length() == 0 || b.Length() == 0 {
+		return 0, false, nil
+	}
+
+	_, _, ext, err := rbo.RBO(a, b, p)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Rounding can push the extrapolated RBO of identical SERPs just past 1
+	return math.Max(0, math.Min(1, 1-ext)), true, nil
+}
@@ -0,0 +1,82 @@
+package volatility
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/thedahv/keyword-cluster-finder/pkg/graph"
+	"github.com/thedahv/keyword-cluster-finder/pkg/rankings"
+	"github.com/thedahv/keyword-cluster-finder/pkg/snapshot"
+)
+
+func serp(keyword string, domains ...string) rankings.SERP {
+	s := rankings.SERP{Keyword: keyword}
+	for i, d := range domains {
+		s.Members = append(s.Members, rankings.SERPMember{Keyword: keyword, Prominence: i + 1, Domain: d})
+	}
+	return s
+}
+
+func TestAnalyze(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 7, d, 0, 0, 0, 0, time.UTC)
+	}
+	snaps := []snapshot.Snapshot{
+		{Date: day(1), KeywordData: rankings.KeywordData{
+			"stable": serp("stable", "a", "b", "c"),
+			"churn":  serp("churn", "a", "b", "c"),
+		}},
+		{Date: day(2), KeywordData: rankings.KeywordData{
+			"stable": serp("stable", "a", "b", "c"),
+			"churn":  serp("churn", "x", "y", "z"),
+		}},
+		{Date: day(3), KeywordData: rankings.KeywordData{
+			"stable": serp("stable", "a", "b", "c"),
+			"churn":  serp("churn", "x", "y", "z"),
+			"new":    serp("new", "a"),
+		}},
+	}
+
+	a, err := Analyze(snaps, 0.9)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if s := a.Keywords["stable"]; math.Abs(s.Volatility) > 1e-9 || s.Changes != 2 {
+		t.Errorf("expected stable keyword to have volatility 0 over 2 changes, got %+v", s)
+	}
+	if s := a.Keywords["churn"]; math.Abs(s.Volatility-0.5) > 1e-9 {
+		t.Errorf("expected churning keyword to have volatility 0.5, got %+v", s)
+	}
+	if _, ok := a.Keywords["new"]; ok {
+		t.Errorf("expected keyword with a single snapshot to be skipped")
+	}
+
+	if l := len(a.Timeline); l != 2 {
+		t.Fatalf("expected 2 timeline points, got %d", l)
+	}
+	if v := a.Timeline[0].Volatility; math.Abs(v-0.5) > 1e-9 {
+		t.Errorf("expected mean volatility 0.5 on day 2, got %f", v)
+	}
+
+	if r := a.Ranked(); r[0].Keyword != "churn" {
+		t.Errorf("expected 'churn' to rank first, got %s", r[0].Keyword)
+	}
+
+	clusters := []graph.ClusterGroup{
+		{Name: "c", Keywords: []string{"stable", "churn", "missing"}},
+		{Name: "s", Keywords: []string{"stable"}},
+		{Name: "m", Keywords: []string{"missing"}},
+	}
+	a.Apply(clusters)
+	if v := clusters[0].Volatility; v == nil || math.Abs(*v-0.25) > 1e-9 {
+		t.Errorf("expected cluster volatility 0.25, got %v", v)
+	}
+	if v := clusters[1].Volatility; v == nil || *v != 0 {
+		t.Errorf("expected a stable cluster to have volatility 0, got %v", v)
+	}
+	if v := clusters[2].Volatility; v != nil {
+		t.Errorf("expected an unmeasured cluster to have no volatility, got %f", *v)
+	}
+}